@@ -3,6 +3,7 @@ package main
 import (
 	"aevum-emporium-be/internal/datasource"
 	"aevum-emporium-be/internal/routes"
+	"aevum-emporium-be/internal/token"
 	"log"
 	"os"
 
@@ -22,6 +23,15 @@ func main() {
 		log.Fatal("Failed to initialize MongoDB connection")
 	}
 
+	if err := datasource.EnsureIndexes(client); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	// Tokens cannot be issued or checked without keys, so refuse to start
+	if err := token.LoadKeys(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
 	// Initialize the Gin router
 	router := gin.Default() // Initialize once
 
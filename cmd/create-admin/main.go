@@ -0,0 +1,78 @@
+// Command create-admin creates the first admin account, or promotes an
+// existing account to admin. Either way the email counts as verified. Values come from flags, falling back to the
+// ADMIN_EMAIL, ADMIN_PASSWORD, ADMIN_FIRST_NAME, ADMIN_LAST_NAME and
+// ADMIN_PHONE_NUMBER environment variables.
+package main
+
+import (
+	"aevum-emporium-be/internal/controllers"
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	email := flag.String("email", os.Getenv("ADMIN_EMAIL"), "admin email address")
+	password := flag.String("password", os.Getenv("ADMIN_PASSWORD"), "admin password (only used when creating the account)")
+	firstName := flag.String("first-name", os.Getenv("ADMIN_FIRST_NAME"), "admin first name")
+	lastName := flag.String("last-name", os.Getenv("ADMIN_LAST_NAME"), "admin last name")
+	phoneNumber := flag.String("phone", os.Getenv("ADMIN_PHONE_NUMBER"), "admin phone number")
+	flag.Parse()
+
+	if *email == "" {
+		log.Fatal("An email is required (-email or ADMIN_EMAIL)")
+	}
+
+	datasource.ConnectDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Promote the account if it already exists. It is marked verified too, or
+	// REQUIRE_EMAIL_VERIFICATION=login could lock out the only admin
+	result, err := controllers.UserCollection.UpdateOne(ctx, bson.M{"email": *email}, bson.M{"$set": bson.M{
+		"role":           models.RoleAdmin,
+		"disabled":       false,
+		"email_verified": true,
+		"updated_at":     time.Now(),
+	}})
+	if err != nil {
+		log.Fatalf("Failed to update user: %v", err)
+	}
+	if result.MatchedCount > 0 {
+		fmt.Println("Promoted existing user", *email, "to admin")
+		return
+	}
+
+	if len(*password) < 8 {
+		log.Fatal("A password of at least 8 characters is required to create the admin (-password or ADMIN_PASSWORD)")
+	}
+
+	user := models.User{
+		UserID:      primitive.NewObjectID(),
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		Password:    controllers.HashPassword(*password),
+		PhoneNumber: *phoneNumber,
+		Address:     make([]models.Address, 0),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		Role:        models.RoleAdmin,
+		// Whoever runs this command vouches for the address
+		EmailVerified: true,
+	}
+	if _, err := controllers.UserCollection.InsertOne(ctx, user); err != nil {
+		log.Fatalf("Failed to create admin: %v", err)
+	}
+
+	fmt.Println("Created admin", *email)
+}
@@ -0,0 +1,87 @@
+// Command migrate-categories moves products from free-form category names to
+// the category collection. Every distinct name on a product without a
+// category_id becomes a top-level category, names sharing a slug ("Shoes" and
+// "shoes") become one, and the products are pointed at it. Running it again
+// only picks up products added since.
+package main
+
+import (
+	"aevum-emporium-be/internal/controllers"
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	datasource.ConnectDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	uncategorised := bson.M{"category_id": bson.M{"$exists": false}}
+	names, err := controllers.ProductCollection.Distinct(ctx, "category", uncategorised)
+	if err != nil {
+		log.Fatalf("Failed to read product categories: %v", err)
+	}
+
+	for _, value := range names {
+		name, ok := value.(string)
+		name = strings.TrimSpace(name)
+		slug := models.Slugify(name)
+		if !ok || slug == "" {
+			continue
+		}
+
+		category, created, err := findOrCreateCategory(ctx, name, slug)
+		if err != nil {
+			log.Fatalf("Failed to create category %q: %v", name, err)
+		}
+		if created {
+			fmt.Println("Created category", category.Name, "("+category.Slug+")")
+		}
+
+		result, err := controllers.ProductCollection.UpdateMany(ctx,
+			bson.M{"category_id": bson.M{"$exists": false}, "category": value},
+			bson.M{"$set": bson.M{"category_id": category.CategoryID, "category": category.Name}},
+		)
+		if err != nil {
+			log.Fatalf("Failed to update products of %q: %v", name, err)
+		}
+		fmt.Printf("Moved %d products from %q to %s\n", result.ModifiedCount, value, category.Slug)
+	}
+}
+
+// findOrCreateCategory returns the category with slug, creating a top-level
+// one named name when there is none.
+func findOrCreateCategory(ctx context.Context, name string, slug string) (models.Category, bool, error) {
+	var category models.Category
+	err := controllers.CategoryCollection.FindOne(ctx, bson.M{"slug": slug}).Decode(&category)
+	if err == nil {
+		return category, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return category, false, err
+	}
+
+	category = models.Category{
+		CategoryID: primitive.NewObjectID(),
+		Name:       name,
+		NameLower:  strings.ToLower(name),
+		Slug:       slug,
+		Ancestors:  make([]primitive.ObjectID, 0),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if _, err := controllers.CategoryCollection.InsertOne(ctx, category); err != nil {
+		return category, false, err
+	}
+	return category, true, nil
+}
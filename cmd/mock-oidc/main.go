@@ -0,0 +1,34 @@
+// Command mock-oidc is a local OpenID Connect provider for trying out and
+// testing social login without a real provider. It signs in every visitor
+// right away, as the email given by the login_hint query parameter or by
+// -email, and accepts any client ID and secret.
+//
+// Point the API at it with:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_ID=aevum
+//	OIDC_MOCK_CLIENT_SECRET=secret
+package main
+
+import (
+	"aevum-emporium-be/internal/oidc/oidctest"
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, must match OIDC_<NAME>_ISSUER")
+	email := flag.String("email", "user@example.com", "email of the signed in user when no login_hint is given")
+	flag.Parse()
+
+	provider, err := oidctest.NewProvider(*issuer, *email)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Mock OIDC provider listening on", *addr, "with issuer", provider.Issuer())
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/mailer"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// useFileOutbox sends the emails of the test to a temporary directory and
+// returns it.
+func useFileOutbox(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	previous := Mailer
+	Mailer = &mailer.FileOutbox{Dir: dir}
+	t.Cleanup(func() { Mailer = previous })
+	return dir
+}
+
+// waitForMail waits until dir holds n messages and returns them oldest first.
+// Some emails are sent in the background after the response.
+func waitForMail(t *testing.T, dir string, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) >= n {
+			sort.Strings(files)
+			messages := make([]string, 0, len(files))
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				messages = append(messages, string(data))
+			}
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox has %d messages, want %d", len(files), n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+var linkTokenPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+// linkToken returns the unescaped token of the link in message.
+func linkToken(t *testing.T, message string) string {
+	t.Helper()
+
+	match := linkTokenPattern.FindStringSubmatch(message)
+	if match == nil {
+		t.Fatalf("no link in message:\n%s", message)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func accountTestRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/auth/user/signup", SignUp())
+	router.POST("/auth/user/login", Login())
+	router.GET("/auth/user/verify", VerifyEmail())
+	router.POST("/auth/user/verify/request", RequestVerificationEmail())
+	router.POST("/auth/user/forgot-password", ForgotPassword())
+	router.POST("/auth/user/reset-password", ResetPassword())
+	return router
+}
+
+func TestSignUpSendsVerificationEmail(t *testing.T) {
+	requireDatabase(t)
+	outbox := useFileOutbox(t)
+	router := accountTestRouter()
+
+	w := serve(t, router, http.MethodPost, "/auth/user/signup", gin.H{
+		"first_name":   "Grace",
+		"last_name":    "Hopper",
+		"email":        "grace@example.com",
+		"password":     "a-long-password",
+		"phone_number": "+15550100001",
+	}, "")
+	decodeResponse(t, w, http.StatusCreated, nil)
+
+	message := waitForMail(t, outbox, 1)[0]
+	if !strings.HasPrefix(message, "To: grace@example.com\nSubject: Verify your email address\n") {
+		t.Fatalf("unexpected verification email:\n%s", message)
+	}
+	verifyPath := "/auth/user/verify?token=" + url.QueryEscape(linkToken(t, message))
+
+	w = serve(t, router, http.MethodGet, verifyPath, nil, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var user models.User
+	if err := UserCollection.FindOne(ctx, bson.M{"email": "grace@example.com"}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified {
+		t.Error("email is not verified after opening the link")
+	}
+
+	// The link works once
+	w = serve(t, router, http.MethodGet, verifyPath, nil, "")
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+
+	w = serve(t, router, http.MethodGet, "/auth/user/verify?token=garbage", nil, "")
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+}
+
+func TestRequestVerificationEmail(t *testing.T) {
+	requireDatabase(t)
+	outbox := useFileOutbox(t)
+	router := accountTestRouter()
+
+	user, _ := createTestUser(t, "unverified@example.com")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{"email_verified": false}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown emails get the same answer and no mail
+	w := serve(t, router, http.MethodPost, "/auth/user/verify/request", gin.H{"email": "nobody@example.com"}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	w = serve(t, router, http.MethodPost, "/auth/user/verify/request", gin.H{"email": user.Email}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+	messages := waitForMail(t, outbox, 1)
+	if !strings.HasPrefix(messages[0], "To: unverified@example.com\nSubject: Verify your email address\n") {
+		t.Fatalf("unexpected verification email:\n%s", messages[0])
+	}
+
+	// A second request within the interval is answered but sends nothing
+	w = serve(t, router, http.MethodPost, "/auth/user/verify/request", gin.H{"email": user.Email}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+	time.Sleep(500 * time.Millisecond)
+	if messages := waitForMail(t, outbox, 1); len(messages) != 1 {
+		t.Errorf("outbox has %d messages, want 1", len(messages))
+	}
+
+	w = serve(t, router, http.MethodGet, "/auth/user/verify?token="+url.QueryEscape(linkToken(t, messages[0])), nil, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+}
+
+func TestPasswordResetByEmail(t *testing.T) {
+	requireDatabase(t)
+	outbox := useFileOutbox(t)
+	router := accountTestRouter()
+
+	user, _ := createTestUser(t, "reset@example.com")
+
+	// Unknown emails get the same answer and no mail
+	w := serve(t, router, http.MethodPost, "/auth/user/forgot-password", gin.H{"email": "nobody@example.com"}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	w = serve(t, router, http.MethodPost, "/auth/user/forgot-password", gin.H{"email": user.Email}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	messages := waitForMail(t, outbox, 1)
+	if len(messages) != 1 {
+		t.Fatalf("outbox has %d messages, want only the one for %s", len(messages), user.Email)
+	}
+	if !strings.HasPrefix(messages[0], "To: reset@example.com\nSubject: Reset your password\n") {
+		t.Fatalf("unexpected reset email:\n%s", messages[0])
+	}
+	resetToken := linkToken(t, messages[0])
+
+	w = serve(t, router, http.MethodPost, "/auth/user/reset-password", gin.H{"token": resetToken, "password": "a-brand-new-password"}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	// The token works once
+	w = serve(t, router, http.MethodPost, "/auth/user/reset-password", gin.H{"token": resetToken, "password": "yet-another-password"}, "")
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+
+	w = serve(t, router, http.MethodPost, "/auth/user/login", gin.H{"email": user.Email, "password": "correct horse battery"}, "")
+	decodeResponse(t, w, http.StatusUnauthorized, nil)
+	w = serve(t, router, http.MethodPost, "/auth/user/login", gin.H{"email": user.Email, "password": "a-brand-new-password"}, "")
+	decodeResponse(t, w, http.StatusOK, nil)
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var APIKeyCollection *mongo.Collection = datasource.APIKeyData(datasource.Client)
+
+// CreateAPIKey issues an API key for an integration. The key itself is only
+// returned in this response; afterwards only its prefix is shown.
+func CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Name        string     `json:"name" validate:"required"`
+			Permissions []string   `json:"permissions" validate:"required,min=1"`
+			ExpiresAt   *time.Time `json:"expires_at"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, permission := range req.Permissions {
+			if !models.IsPermission(permission) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission " + permission})
+				return
+			}
+			// Keys must not be able to mint more keys
+			if permission == models.PermissionAPIKeysManage {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "API keys cannot manage API keys"})
+				return
+			}
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+			return
+		}
+
+		createdBy, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		key, hash, prefix, err := generate.GenerateAPIKey()
+		if err != nil {
+			log.Println("Error generating API key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+			return
+		}
+
+		apiKey := models.APIKey{
+			KeyID:       primitive.NewObjectID(),
+			Name:        req.Name,
+			Prefix:      prefix,
+			KeyHash:     hash,
+			Permissions: req.Permissions,
+			CreatedBy:   createdBy,
+			CreatedAt:   time.Now(),
+			ExpiresAt:   req.ExpiresAt,
+		}
+		if _, err := APIKeyCollection.InsertOne(ctx, apiKey); err != nil {
+			log.Println("Error saving API key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"api_key": apiKey, "key": key})
+	}
+}
+
+// ListAPIKeys returns all API keys, newest first. The keys themselves are
+// never included.
+func ListAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		apiKeys := make([]models.APIKey, 0)
+		cursor, err := APIKeyCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+		if err != nil {
+			log.Println("Error fetching API keys:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching API keys"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		if err := cursor.All(ctx, &apiKeys); err != nil {
+			log.Println("Error decoding API keys:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding API keys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, apiKeys)
+	}
+}
+
+// RevokeAPIKey stops an API key from being accepted. The key is kept so past
+// changes made with it can still be traced.
+func RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		keyObjectID, err := primitive.ObjectIDFromHex(c.Param("key_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+			return
+		}
+
+		now := time.Now()
+		result, err := APIKeyCollection.UpdateOne(ctx,
+			bson.M{"_id": keyObjectID, "revoked": false},
+			bson.M{"$set": bson.M{"revoked": true, "revoked_at": now}},
+		)
+		if err != nil {
+			log.Println("Error revoking API key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking API key"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
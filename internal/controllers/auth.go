@@ -5,8 +5,11 @@ import (
 	"aevum-emporium-be/internal/models"
 	generate "aevum-emporium-be/internal/token"
 	"context"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +31,10 @@ func HashPassword(password string) string {
 	return string(bytes)
 }
 
+// dummyPasswordHash is compared against for unknown emails, so a failed login
+// takes as long whether or not the account exists. Its cost matches HashPassword.
+const dummyPasswordHash = "$2a$14$4/FB3HPshrIa1q3SYYAf4.nzGji/YYdHDIkO6Otn7t.yw3It59n62"
+
 func VerifyPassword(userpassword string, givenpassword string) (bool, string) {
 	err := bcrypt.CompareHashAndPassword([]byte(givenpassword), []byte(userpassword))
 	valid := true
@@ -44,18 +51,33 @@ func SignUp() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		// Only these fields can be chosen at signup; the role in particular is not
+		var signUpDetails struct {
+			FirstName   string `json:"first_name" validate:"required"`
+			LastName    string `json:"last_name" validate:"required"`
+			Email       string `json:"email" validate:"required,email"`
+			Password    string `json:"password" validate:"required,min=8"`
+			PhoneNumber string `json:"phone_number" validate:"required"`
+		}
+		if err := c.BindJSON(&signUpDetails); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		validationErr := Validate.Struct(user)
+		validationErr := Validate.Struct(signUpDetails)
 		if validationErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
 
+		user := models.User{
+			FirstName:   signUpDetails.FirstName,
+			LastName:    signUpDetails.LastName,
+			Email:       signUpDetails.Email,
+			Password:    signUpDetails.Password,
+			PhoneNumber: signUpDetails.PhoneNumber,
+		}
+
 		// Check if email already exists
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
@@ -89,15 +111,11 @@ func SignUp() gin.HandlerFunc {
 		user.UpdatedAt = time.Now()
 		user.UserID = primitive.NewObjectID()
 
-		// Default role to "customer" if not provided
-		if user.Role == "" {
-			user.Role = "customer"
-		}
+		// New accounts are always customers; staff roles are granted by an admin
+		user.Role = models.RoleCustomer
 
-		// Initialize address list if not provided
-		if user.Address == nil {
-			user.Address = make([]models.Address, 0)
-		}
+		// Initialize address list
+		user.Address = make([]models.Address, 0)
 
 		// Insert the user into the database
 		_, insertErr := UserCollection.InsertOne(ctx, user)
@@ -106,7 +124,12 @@ func SignUp() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"message": "Successfully signed up!"})
+		// A failed email is not fatal, the user can ask for a new link
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Successfully signed up! Please check your email to verify your account."})
 	}
 }
 
@@ -134,35 +157,202 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		// Find the user in the database
-		err := UserCollection.FindOne(ctx, bson.M{"email": userLoginDetails.Email}).Decode(&foundUser)
+		ip := c.ClientIP()
+		userAgent := c.Request.UserAgent()
+
+		// Refuse locked accounts and IPs before spending time on bcrypt
+		remaining, err := loginLockRemaining(ctx, userLoginDetails.Email, ip)
 		if err != nil {
+			log.Println("Error checking login lock:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+		if remaining > 0 {
+			if err := auditLoginFailure(ctx, userLoginDetails.Email, nil, ip, userAgent, "locked"); err != nil {
+				log.Println("Error auditing login:", err)
+			}
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please try again later"})
+			return
+		}
+
+		// failLogin counts the failed attempt and answers with a generic error
+		failLogin := func(userID *primitive.ObjectID, reason string) {
+			if err := recordLoginFailure(ctx, userLoginDetails.Email, ip); err != nil {
+				log.Println("Error recording login failure:", err)
+			}
+			if err := auditLoginFailure(ctx, userLoginDetails.Email, userID, ip, userAgent, reason); err != nil {
+				log.Println("Error auditing login:", err)
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		}
+
+		// Find the user in the database
+		err = UserCollection.FindOne(ctx, bson.M{"email": userLoginDetails.Email}).Decode(&foundUser)
+		if err != nil {
+			VerifyPassword(userLoginDetails.Password, dummyPasswordHash)
+			failLogin(nil, "unknown account")
 			return
 		}
 
 		// Verify password
-		isValidPassword, msg := VerifyPassword(userLoginDetails.Password, foundUser.Password)
+		isValidPassword, _ := VerifyPassword(userLoginDetails.Password, foundUser.Password)
 		if !isValidPassword {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			failLogin(&foundUser.UserID, "wrong password")
+			return
+		}
+
+		if err := clearLoginFailures(ctx, userLoginDetails.Email); err != nil {
+			log.Println("Error clearing login failures:", err)
+		}
+
+		if foundUser.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+			return
+		}
+
+		if emailVerificationRequiredFor["login"] && !foundUser.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before logging in"})
+			return
+		}
+
+		completeLogin(ctx, c, foundUser)
+	}
+}
+
+// completeLogin finishes the first authentication step of a user. Users with
+// two-factor authentication get a challenge token, which /mfa/verify
+// exchanges for a token pair; everyone else gets a session right away.
+func completeLogin(ctx context.Context, c *gin.Context, user models.User) {
+	if user.MFAEnabled {
+		mfaToken, err := generate.GenerateMFAChallengeToken(user.Email, user.UserID.Hex())
+		if err != nil {
+			log.Println("Error generating MFA challenge:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	respondWithSession(ctx, c, user, false)
+}
+
+// respondWithSession starts a session for a user who has authenticated and
+// sends back their details with its first token pair.
+func respondWithSession(ctx context.Context, c *gin.Context, user models.User, mfa bool) {
+	token, refreshToken, err := generate.NewSession(ctx, user.Email, user.FirstName, user.LastName, user.UserID.Hex(), user.Role, mfa)
+	if err != nil {
+		log.Println("Error creating session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+		return
+	}
+
+	// Carry over what the user put in their cart before logging in
+	if cartToken := c.GetHeader(CartTokenHeader); cartToken != "" {
+		if err := mergeGuestCart(ctx, user.UserID, cartToken); err != nil {
+			log.Println("Error merging guest cart:", err)
+		}
+	}
+
+	// Send user information back to the client (excluding sensitive fields)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":       user.UserID,
+		"first_name":    user.FirstName,
+		"last_name":     user.LastName,
+		"email":         user.Email,
+		"phone_number":  user.PhoneNumber,
+		"address":       user.Address,
+		"role":          user.Role,
+		"mfa_enabled":   user.MFAEnabled,
+		"token":         token,
+		"refresh_token": refreshToken,
+	})
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh token pair.
+// Each refresh token can be used once; reusing one revokes its session.
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			RefreshToken string `json:"refresh_token" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Generate tokens and update them in the database
-		token, refreshToken, _ := generate.TokenGenerator(foundUser.Email, foundUser.FirstName, foundUser.LastName, foundUser.UserID.Hex())
-		generate.UpdateAllTokens(token, refreshToken, foundUser.UserID.Hex())
+		token, refreshToken, err := generate.RotateRefreshToken(ctx, req.RefreshToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, generate.ErrInvalidRefreshToken),
+				errors.Is(err, generate.ErrRefreshTokenReused),
+				errors.Is(err, generate.ErrSessionRevoked):
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			case errors.Is(err, generate.ErrAccountDisabled):
+				c.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+			default:
+				log.Println("Error refreshing token:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+			}
+			return
+		}
 
-		// Send user information back to the client (excluding sensitive fields)
 		c.JSON(http.StatusOK, gin.H{
-			"user_id":       foundUser.UserID,
-			"first_name":    foundUser.FirstName,
-			"last_name":     foundUser.LastName,
-			"email":         foundUser.Email,
-			"phone_number":  foundUser.PhoneNumber,
-			"address":       foundUser.Address,
-			"role":          foundUser.Role,
 			"token":         token,
 			"refresh_token": refreshToken,
 		})
 	}
 }
+
+// Logout revokes the session of the presented token. Its access and refresh
+// tokens stop working immediately.
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sessionID, err := primitive.ObjectIDFromHex(c.GetString("sid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		if err := generate.RevokeSession(ctx, sessionID, "logout"); err != nil {
+			log.Println("Error revoking session:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+	}
+}
+
+// LogoutAll revokes every session of the authenticated user.
+func LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userObjectID, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		if err := generate.RevokeAllSessions(ctx, userObjectID, "logout everywhere"); err != nil {
+			log.Println("Error revoking sessions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out of all sessions"})
+	}
+}
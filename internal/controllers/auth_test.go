@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyPasswordHashCost(t *testing.T) {
+	// A malformed hash would fail at once and give unknown emails away
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatal("dummyPasswordHash is not a bcrypt hash:", err)
+	}
+	hashCost, err := bcrypt.Cost([]byte(HashPassword("a-long-password")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != hashCost {
+		t.Errorf("dummyPasswordHash has cost %d, HashPassword uses %d", cost, hashCost)
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"aevum-emporium-be/internal/datasource"
 	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
 	"context"
 	"log"
 	"net/http"
@@ -16,7 +17,78 @@ import (
 
 var CartCollection *mongo.Collection = datasource.CartData(datasource.Client)
 
-// AddToCart adds a product to the user's cart
+// CartTokenHeader carries the token of a guest cart.
+const CartTokenHeader = "X-Cart-Token"
+
+// cartOwner says whose cart a request is about: an authenticated user's, or
+// a guest cart named by its cart token.
+type cartOwner struct {
+	userID primitive.ObjectID // zero for guests
+	cartID primitive.ObjectID // the guest's cart, zero while they have none
+}
+
+func (o cartOwner) guest() bool {
+	return o.userID.IsZero()
+}
+
+// filter matches the owner's cart. For a guest without a cart it matches nothing.
+func (o cartOwner) filter() bson.M {
+	if o.guest() {
+		return bson.M{"_id": o.cartID, "user_id": primitive.NilObjectID}
+	}
+	return bson.M{"user_id": o.userID}
+}
+
+// requestCartOwner resolves the cart owner of the request. It returns false
+// after answering the request when the user ID or cart token is invalid.
+func requestCartOwner(c *gin.Context) (cartOwner, bool) {
+	var owner cartOwner
+
+	if userID := c.GetString("uid"); userID != "" {
+		userObjID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return owner, false
+		}
+		owner.userID = userObjID
+		return owner, true
+	}
+
+	cartToken := c.GetHeader(CartTokenHeader)
+	if cartToken == "" {
+		return owner, true
+	}
+	cartID, ok := cartIDFromToken(cartToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired cart token"})
+		return owner, false
+	}
+	owner.cartID = cartID
+	return owner, true
+}
+
+func cartIDFromToken(cartToken string) (primitive.ObjectID, bool) {
+	claims, err := generate.ValidateCartToken(cartToken)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	cartID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return cartID, true
+}
+
+func cartTotal(items []models.CartItem) float64 {
+	total := 0.0
+	for _, item := range items {
+		total += item.Price * float64(item.Quantity)
+	}
+	return total
+}
+
+// AddToCart adds a product to the user's cart. Guests without a cart get a
+// new one, together with the cart token to send in X-Cart-Token from then on.
 func AddToCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -28,36 +100,43 @@ func AddToCart() gin.HandlerFunc {
 			return
 		}
 
-		userID := c.GetString("uid") // Assume user ID is extracted from token middleware
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		userObjID, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		owner, ok := requestCartOwner(c)
+		if !ok {
 			return
 		}
 
 		var cart models.Cart
-		err = CartCollection.FindOne(ctx, bson.M{"user_id": userObjID}).Decode(&cart)
+		err := CartCollection.FindOne(ctx, owner.filter()).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				// Create a new cart if none exists
 				cart = models.Cart{
 					CartID:    primitive.NewObjectID(),
-					UserID:    userObjID,
+					UserID:    owner.userID,
 					Items:     []models.CartItem{cartItem},
 					Total:     cartItem.Price * float64(cartItem.Quantity),
 					CreatedAt: time.Now(),
 				}
+
+				response := gin.H{"message": "Item added to cart", "cart": &cart}
+				if owner.guest() {
+					cartToken, err := generate.GenerateCartToken(cart.CartID.Hex())
+					if err != nil {
+						log.Println("Error generating cart token:", err)
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating cart"})
+						return
+					}
+					expiresAt := cart.CreatedAt.Add(generate.CartTokenLifetime)
+					cart.ExpiresAt = &expiresAt
+					response["cart_token"] = cartToken
+				}
+
 				_, err := CartCollection.InsertOne(ctx, cart)
 				if err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating cart"})
 					return
 				}
-				c.JSON(http.StatusOK, gin.H{"message": "Item added to cart", "cart": cart})
+				c.JSON(http.StatusOK, response)
 				return
 			} else {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving cart"})
@@ -81,10 +160,7 @@ func AddToCart() gin.HandlerFunc {
 		}
 
 		// Update the total price
-		cart.Total = 0
-		for _, item := range cart.Items {
-			cart.Total += item.Price * float64(item.Quantity)
-		}
+		cart.Total = cartTotal(cart.Items)
 
 		_, err = CartCollection.UpdateOne(ctx, bson.M{"_id": cart.CartID}, bson.M{"$set": bson.M{"items": cart.Items, "total": cart.Total}})
 		if err != nil {
@@ -96,19 +172,11 @@ func AddToCart() gin.HandlerFunc {
 	}
 }
 
-// ViewCart fetches the cart items for a user
+// ViewCart fetches the cart items for a user or guest
 func ViewCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetString("uid") // Assume user ID is extracted from token middleware
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		// Convert the string userID to ObjectID
-		objID, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		owner, ok := requestCartOwner(c)
+		if !ok {
 			return
 		}
 
@@ -116,8 +184,7 @@ func ViewCart() gin.HandlerFunc {
 		defer cancel()
 
 		var cart models.Cart
-		// Query using the ObjectID for user_id
-		err = CartCollection.FindOne(ctx, bson.M{"user_id": objID}).Decode(&cart)
+		err := CartCollection.FindOne(ctx, owner.filter()).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				// If no cart document found, respond with a 404
@@ -133,21 +200,14 @@ func ViewCart() gin.HandlerFunc {
 	}
 }
 
-// RemoveFromCart removes a specific product from the user's cart
+// RemoveFromCart removes a specific product from the user's or guest's cart
 func RemoveFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		userID := c.GetString("uid") // Assume user ID is extracted from token middleware
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		userObjID, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		owner, ok := requestCartOwner(c)
+		if !ok {
 			return
 		}
 
@@ -159,7 +219,7 @@ func RemoveFromCart() gin.HandlerFunc {
 		}
 
 		var cart models.Cart
-		err = CartCollection.FindOne(ctx, bson.M{"user_id": userObjID}).Decode(&cart)
+		err = CartCollection.FindOne(ctx, owner.filter()).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
@@ -186,10 +246,7 @@ func RemoveFromCart() gin.HandlerFunc {
 		}
 
 		// Recalculate the total price
-		cart.Total = 0
-		for _, item := range cart.Items {
-			cart.Total += item.Price * float64(item.Quantity)
-		}
+		cart.Total = cartTotal(cart.Items)
 
 		_, err = CartCollection.UpdateOne(ctx, bson.M{"_id": cart.CartID}, bson.M{"$set": bson.M{"items": cart.Items, "total": cart.Total}})
 		if err != nil {
@@ -201,26 +258,19 @@ func RemoveFromCart() gin.HandlerFunc {
 	}
 }
 
-// ClearCart removes all items from the user's cart
+// ClearCart removes all items from the user's or guest's cart
 func ClearCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		userID := c.GetString("uid") // Assume user ID is extracted from token middleware
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		userObjID, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		owner, ok := requestCartOwner(c)
+		if !ok {
 			return
 		}
 
 		var cart models.Cart
-		err = CartCollection.FindOne(ctx, bson.M{"user_id": userObjID}).Decode(&cart)
+		err := CartCollection.FindOne(ctx, owner.filter()).Decode(&cart)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
@@ -243,3 +293,56 @@ func ClearCart() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Cart cleared", "cart": cart})
 	}
 }
+
+// mergeGuestCart moves the items of the guest cart named by cartToken into
+// the user's cart and deletes the guest cart. Quantities of products in both
+// carts are added up.
+func mergeGuestCart(ctx context.Context, userID primitive.ObjectID, cartToken string) error {
+	cartID, ok := cartIDFromToken(cartToken)
+	if !ok {
+		return nil
+	}
+
+	var guestCart models.Cart
+	err := CartCollection.FindOneAndDelete(ctx, cartOwner{cartID: cartID}.filter()).Decode(&guestCart)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+	if len(guestCart.Items) == 0 {
+		return nil
+	}
+
+	var cart models.Cart
+	err = CartCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		guestCart.UserID = userID
+		guestCart.ExpiresAt = nil
+		_, err = CartCollection.InsertOne(ctx, guestCart)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, guestItem := range guestCart.Items {
+		merged := false
+		for i, item := range cart.Items {
+			if item.ProductID == guestItem.ProductID {
+				cart.Items[i].Quantity += guestItem.Quantity
+				cart.Items[i].Price = guestItem.Price
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			cart.Items = append(cart.Items, guestItem)
+		}
+	}
+	cart.Total = cartTotal(cart.Items)
+
+	_, err = CartCollection.UpdateOne(ctx, bson.M{"_id": cart.CartID}, bson.M{"$set": bson.M{"items": cart.Items, "total": cart.Total}})
+	return err
+}
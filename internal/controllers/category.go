@@ -0,0 +1,485 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var CategoryCollection *mongo.Collection = datasource.CategoryData(datasource.Client)
+
+var errUnknownCategory = errors.New("Unknown category")
+
+// categorySort lists siblings in their configured order.
+var categorySort = bson.D{{Key: "position", Value: 1}, {Key: "name", Value: 1}}
+
+// findCategory loads a category by its ID or slug. Names work too as long as
+// they turn into the slug, so "Shoes" finds the category "shoes".
+func findCategory(ctx context.Context, ref string) (models.Category, error) {
+	var category models.Category
+	filter := bson.M{"slug": models.Slugify(ref)}
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter = bson.M{"_id": id}
+	}
+	err := CategoryCollection.FindOne(ctx, filter).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		return category, errUnknownCategory
+	}
+	return category, err
+}
+
+// categoryWithDescendants returns the ID of category and of every category below it.
+func categoryWithDescendants(ctx context.Context, category models.Category) ([]primitive.ObjectID, error) {
+	cursor, err := CategoryCollection.Find(ctx, bson.M{"ancestors": category.CategoryID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var descendants []models.Category
+	if err := cursor.All(ctx, &descendants); err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{category.CategoryID}
+	for _, descendant := range descendants {
+		ids = append(ids, descendant.CategoryID)
+	}
+	return ids, nil
+}
+
+// applyCategoryFilter narrows filter to the products of ?category= (an ID or
+// slug) and its subcategories. It returns false after answering the request
+// when the category does not exist or cannot be loaded.
+func applyCategoryFilter(ctx context.Context, c *gin.Context, filter bson.M) bool {
+	ref := c.Query("category")
+	if ref == "" {
+		return true
+	}
+
+	category, err := findCategory(ctx, ref)
+	if err != nil {
+		if err == errUnknownCategory {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		log.Println("Error fetching category:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+		return false
+	}
+
+	ids, err := categoryWithDescendants(ctx, category)
+	if err != nil {
+		log.Println("Error fetching subcategories:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+		return false
+	}
+	filter["category_id"] = bson.M{"$in": ids}
+	return true
+}
+
+// resolveProductCategory points product at the category given by its
+// category_id or, failing that, by its category name or slug, and copies the
+// category's name onto it. Products without either stay uncategorised.
+func resolveProductCategory(ctx context.Context, product *models.Product) error {
+	ref := product.Category
+	if product.CategoryID != nil {
+		ref = product.CategoryID.Hex()
+	}
+	if ref == "" {
+		return nil
+	}
+
+	category, err := findCategory(ctx, ref)
+	if err != nil {
+		return err
+	}
+	product.CategoryID = &category.CategoryID
+	product.Category = category.Name
+	return nil
+}
+
+// respondProductCategoryError answers a product write whose category could
+// not be resolved.
+func respondProductCategoryError(c *gin.Context, err error) {
+	if err == errUnknownCategory {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Println("Error fetching product category:", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+}
+
+// categoryRequest is the body of CreateCategory and UpdateCategory.
+type categoryRequest struct {
+	Name     string              `json:"name" validate:"required,max=100"`
+	Slug     string              `json:"slug" validate:"max=100"` // derived from the name when empty
+	ParentID *primitive.ObjectID `json:"parent_id"`               // null for a top-level category
+	Position int                 `json:"position"`
+}
+
+// bindCategoryRequest reads and validates the request body and works out the
+// slug and ancestors it asks for. It returns false after answering the request
+// when the body is invalid.
+func bindCategoryRequest(ctx context.Context, c *gin.Context) (categoryRequest, []primitive.ObjectID, bool) {
+	var req categoryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, nil, false
+	}
+	if err := Validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, nil, false
+	}
+
+	if req.Slug == "" {
+		req.Slug = req.Name
+	}
+	req.Slug = models.Slugify(req.Slug)
+	if req.Slug == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The slug must contain letters or digits"})
+		return req, nil, false
+	}
+	// Slugs and IDs share the URL, a slug that reads as an ID would be unreachable
+	if primitive.IsValidObjectID(req.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The slug cannot look like a category ID"})
+		return req, nil, false
+	}
+
+	ancestors := make([]primitive.ObjectID, 0)
+	if req.ParentID != nil {
+		var parent models.Category
+		err := CategoryCollection.FindOne(ctx, bson.M{"_id": *req.ParentID}).Decode(&parent)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+				return req, nil, false
+			}
+			log.Println("Error fetching parent category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching parent category"})
+			return req, nil, false
+		}
+		ancestors = append(parent.Ancestors, parent.CategoryID)
+	}
+
+	return req, ancestors, true
+}
+
+// categoryNode is a category with its subcategories, for the category tree.
+type categoryNode struct {
+	models.Category
+	Children []*categoryNode `json:"children"`
+}
+
+// GetCategoryTree returns every category as a tree, siblings in their
+// configured order.
+func GetCategoryTree() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var categories []models.Category
+		cursor, err := CategoryCollection.Find(ctx, bson.M{}, options.Find().SetSort(categorySort))
+		if err != nil {
+			log.Println("Error fetching categories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching categories"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		if err := cursor.All(ctx, &categories); err != nil {
+			log.Println("Error decoding categories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding categories"})
+			return
+		}
+
+		nodes := make(map[primitive.ObjectID]*categoryNode, len(categories))
+		for _, category := range categories {
+			nodes[category.CategoryID] = &categoryNode{Category: category, Children: make([]*categoryNode, 0)}
+		}
+		roots := make([]*categoryNode, 0)
+		for _, category := range categories {
+			node := nodes[category.CategoryID]
+			if parent, ok := nodes[derefObjectID(category.ParentID)]; ok {
+				parent.Children = append(parent.Children, node)
+			} else {
+				roots = append(roots, node)
+			}
+		}
+
+		c.JSON(http.StatusOK, roots)
+	}
+}
+
+func derefObjectID(id *primitive.ObjectID) primitive.ObjectID {
+	if id == nil {
+		return primitive.NilObjectID
+	}
+	return *id
+}
+
+// GetCategory returns a category by ID or slug together with its breadcrumbs
+// (the categories above it, top-level first) and its direct subcategories.
+func GetCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := findCategory(ctx, c.Param("category"))
+		if err != nil {
+			if err == errUnknownCategory {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+				return
+			}
+			log.Println("Error fetching category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+
+		var ancestors []models.Category
+		cursor, err := CategoryCollection.Find(ctx, bson.M{"_id": bson.M{"$in": category.Ancestors}})
+		if err != nil {
+			log.Println("Error fetching parent categories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+		defer cursor.Close(ctx)
+		if err := cursor.All(ctx, &ancestors); err != nil {
+			log.Println("Error decoding parent categories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+		byID := make(map[primitive.ObjectID]models.Category, len(ancestors))
+		for _, ancestor := range ancestors {
+			byID[ancestor.CategoryID] = ancestor
+		}
+		breadcrumbs := make([]models.Category, 0, len(category.Ancestors))
+		for _, id := range category.Ancestors {
+			if ancestor, ok := byID[id]; ok {
+				breadcrumbs = append(breadcrumbs, ancestor)
+			}
+		}
+
+		children := make([]models.Category, 0)
+		childCursor, err := CategoryCollection.Find(ctx, bson.M{"parent_id": category.CategoryID}, options.Find().SetSort(categorySort))
+		if err != nil {
+			log.Println("Error fetching subcategories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+		defer childCursor.Close(ctx)
+		if err := childCursor.All(ctx, &children); err != nil {
+			log.Println("Error decoding subcategories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"category": category, "breadcrumbs": breadcrumbs, "children": children})
+	}
+}
+
+// CreateCategory adds a category, at the top level or under parent_id.
+func CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		req, ancestors, ok := bindCategoryRequest(ctx, c)
+		if !ok {
+			return
+		}
+
+		category := models.Category{
+			CategoryID: primitive.NewObjectID(),
+			Name:       req.Name,
+			NameLower:  strings.ToLower(req.Name),
+			Slug:       req.Slug,
+			ParentID:   req.ParentID,
+			Ancestors:  ancestors,
+			Position:   req.Position,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if _, err := CategoryCollection.InsertOne(ctx, category); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "A category with this slug already exists"})
+				return
+			}
+			log.Println("Error creating category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Category could not be created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory replaces a category's name, slug, parent and position.
+// Moving a category moves its whole subtree, and a new name is copied onto
+// its products.
+func UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := findCategory(ctx, c.Param("category"))
+		if err != nil {
+			if err == errUnknownCategory {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+				return
+			}
+			log.Println("Error fetching category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+
+		req, ancestors, ok := bindCategoryRequest(ctx, c)
+		if !ok {
+			return
+		}
+		for _, ancestor := range ancestors {
+			if ancestor == category.CategoryID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be moved below itself"})
+				return
+			}
+		}
+		if req.ParentID != nil && *req.ParentID == category.CategoryID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be moved below itself"})
+			return
+		}
+
+		updated := category
+		updated.Name = req.Name
+		updated.NameLower = strings.ToLower(req.Name)
+		updated.Slug = req.Slug
+		updated.ParentID = req.ParentID
+		updated.Ancestors = ancestors
+		updated.Position = req.Position
+		updated.UpdatedAt = time.Now()
+
+		err = runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			_, err := CategoryCollection.UpdateOne(sessCtx, bson.M{"_id": category.CategoryID}, bson.M{"$set": bson.M{
+				"name":       updated.Name,
+				"name_lower": updated.NameLower,
+				"slug":       updated.Slug,
+				"parent_id":  updated.ParentID,
+				"ancestors":  updated.Ancestors,
+				"position":   updated.Position,
+				"updated_at": updated.UpdatedAt,
+			}})
+			if err != nil {
+				return err
+			}
+			if err := moveSubcategories(sessCtx, updated); err != nil {
+				return err
+			}
+			if updated.Name != category.Name {
+				_, err := ProductCollection.UpdateMany(sessCtx, bson.M{"category_id": category.CategoryID}, bson.M{"$set": bson.M{"category": updated.Name}})
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "A category with this slug already exists"})
+				return
+			}
+			log.Println("Error updating category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating category"})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// moveSubcategories rewrites the ancestors of every category below category
+// after category itself got new ancestors.
+func moveSubcategories(sessCtx mongo.SessionContext, category models.Category) error {
+	cursor, err := CategoryCollection.Find(sessCtx, bson.M{"ancestors": category.CategoryID})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(sessCtx)
+
+	var descendants []models.Category
+	if err := cursor.All(sessCtx, &descendants); err != nil {
+		return err
+	}
+
+	for _, descendant := range descendants {
+		// Keep the part of the path below category, replace the part above it
+		ancestors := append(append([]primitive.ObjectID{}, category.Ancestors...), category.CategoryID)
+		for i, id := range descendant.Ancestors {
+			if id == category.CategoryID {
+				ancestors = append(ancestors, descendant.Ancestors[i+1:]...)
+				break
+			}
+		}
+		_, err := CategoryCollection.UpdateOne(sessCtx, bson.M{"_id": descendant.CategoryID}, bson.M{"$set": bson.M{"ancestors": ancestors}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCategory removes a category that has neither subcategories nor products.
+func DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := findCategory(ctx, c.Param("category"))
+		if err != nil {
+			if err == errUnknownCategory {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+				return
+			}
+			log.Println("Error fetching category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching category"})
+			return
+		}
+
+		children, err := CategoryCollection.CountDocuments(ctx, bson.M{"parent_id": category.CategoryID})
+		if err != nil {
+			log.Println("Error counting subcategories:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting category"})
+			return
+		}
+		if children > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Move or delete its subcategories first"})
+			return
+		}
+
+		products, err := ProductCollection.CountDocuments(ctx, bson.M{"category_id": category.CategoryID})
+		if err != nil {
+			log.Println("Error counting category products:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting category"})
+			return
+		}
+		if products > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Move its products to another category first"})
+			return
+		}
+
+		if _, err := CategoryCollection.DeleteOne(ctx, bson.M{"_id": category.CategoryID}); err != nil {
+			log.Println("Error deleting category:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting category"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Category successfully deleted"})
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/mailer"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrderTokenHeader carries the token that lets a guest pay for their order.
+const OrderTokenHeader = "X-Order-Token"
+
+// sendGuestOrderEmail tells a guest their order number, which together with
+// their email address is how they find the order again.
+func sendGuestOrderEmail(ctx context.Context, order models.Order) error {
+	return Mailer.Send(ctx, mailer.Message{
+		To:      order.GuestEmail,
+		Subject: "Your order " + order.OrderNumber,
+		Body: fmt.Sprintf("Thank you for your order.\n\nYour order number is %s, the total is %.2f. You can look the order up with this number and your email address.\n",
+			order.OrderNumber, order.TotalPrice),
+	})
+}
+
+// guestOrderResponse returns the order together with the token needed to pay for it.
+func guestOrderResponse(c *gin.Context, status int, message string, order models.Order) {
+	orderToken, err := generate.GenerateGuestOrderToken(order.OrderID.Hex(), order.GuestEmail)
+	if err != nil {
+		log.Println("Error generating order token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating order token"})
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"message":      message,
+		"order":        order,
+		"order_number": order.OrderNumber,
+		"order_token":  orderToken,
+	})
+}
+
+// GuestCheckout turns the guest cart named by the X-Cart-Token header into an
+// order for the given email address. Like Checkout, prices come from the
+// product catalog. The response carries the order token to send in
+// X-Order-Token when paying.
+func GuestCheckout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		cartID, ok := cartIDFromToken(c.GetHeader(CartTokenHeader))
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired cart token"})
+			return
+		}
+
+		var cart models.Cart
+		err := CartCollection.FindOne(ctx, cartOwner{cartID: cartID}.filter()).Decode(&cart)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+				return
+			}
+			log.Println("Error fetching cart:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+			return
+		}
+
+		items, total, discount, err := priceCartItems(ctx, cart.Items)
+		if err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
+		}
+
+		orderNumber, err := newOrderNumber()
+		if err != nil {
+			log.Println("Error generating order number:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not place order"})
+			return
+		}
+
+		order := models.Order{
+			OrderID:       primitive.NewObjectID(),
+			OrderNumber:   orderNumber,
+			GuestEmail:    normalizeEmail(req.Email),
+			Items:         items,
+			TotalPrice:    total,
+			Discount:      &discount,
+			OrderedAt:     time.Now(),
+			Status:        models.OrderStatusPendingPayment,
+			StatusHistory: newStatusHistory(statusActor{}),
+			PaymentStatus: models.PaymentStatusPending,
+		}
+
+		if err := createOrderFromCart(ctx, cart, order); err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
+		}
+
+		if err := sendGuestOrderEmail(ctx, order); err != nil {
+			// The order stands, the guest still gets the number in the response
+			log.Println("Error sending order confirmation:", err)
+		}
+
+		guestOrderResponse(c, http.StatusCreated, "Order placed successfully", order)
+	}
+}
+
+// LookupGuestOrder finds a guest order by its order number and the email
+// address it was placed with. Both must match, and the same error is returned
+// whichever does not.
+func LookupGuestOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			OrderNumber string `json:"order_number" validate:"required"`
+			Email       string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var order models.Order
+		err := OrderCollection.FindOne(ctx, bson.M{
+			"order_number": strings.ToUpper(strings.TrimSpace(req.OrderNumber)),
+			"guest_email":  normalizeEmail(req.Email),
+			"user_id":      primitive.NilObjectID,
+		}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				return
+			}
+			log.Println("Error fetching order:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching order"})
+			return
+		}
+
+		guestOrderResponse(c, http.StatusOK, "Order found", order)
+	}
+}
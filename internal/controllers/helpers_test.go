@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+var (
+	databaseOnce   sync.Once
+	databaseSkip   string
+	databaseErr    error
+	signingKeyOnce sync.Once
+	signingKeyErr  error
+)
+
+// requireDatabase skips the test unless MONGO_DATABASE names a test database
+// on a reachable MongoDB. Transactions need a replica set. The database is
+// dropped and its indexes created once per run.
+func requireDatabase(t *testing.T) {
+	t.Helper()
+
+	if !strings.Contains(datasource.DatabaseName(), "test") {
+		t.Skip("set MONGO_DATABASE to a test database such as aevum-emporium-test to run this test")
+	}
+
+	databaseOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := datasource.Client.Ping(ctx, nil); err != nil {
+			databaseSkip = "MongoDB is not reachable: " + err.Error()
+			return
+		}
+		if databaseErr = datasource.Client.Database(datasource.DatabaseName()).Drop(ctx); databaseErr != nil {
+			return
+		}
+		databaseErr = datasource.EnsureIndexes(datasource.Client)
+	})
+	if databaseSkip != "" {
+		t.Skip(databaseSkip)
+	}
+	if databaseErr != nil {
+		t.Fatal("Error preparing the test database:", databaseErr)
+	}
+
+	loadSigningKey(t)
+}
+
+// loadSigningKey loads a fresh Ed25519 key to sign tokens with.
+func loadSigningKey(t *testing.T) {
+	t.Helper()
+
+	signingKeyOnce.Do(func() {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			signingKeyErr = err
+			return
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			signingKeyErr = err
+			return
+		}
+		dir, err := os.MkdirTemp("", "aevum-keys")
+		if err != nil {
+			signingKeyErr = err
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "signing.pem")
+		if signingKeyErr = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); signingKeyErr != nil {
+			return
+		}
+		os.Setenv("JWT_SIGNING_KEY_FILE", path)
+		signingKeyErr = generate.LoadKeys()
+	})
+	if signingKeyErr != nil {
+		t.Fatal("Error loading the signing key:", signingKeyErr)
+	}
+}
+
+// createTestUser inserts a verified customer with a phone number and returns
+// it together with an access token of a new session.
+func createTestUser(t *testing.T, email string) (models.User, string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	user := models.User{
+		UserID:        primitive.NewObjectID(),
+		FirstName:     "Ada",
+		LastName:      "Lovelace",
+		Email:         email,
+		Password:      HashPassword("correct horse battery"),
+		PhoneNumber:   "+1555" + primitive.NewObjectID().Hex()[18:],
+		Address:       []models.Address{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Role:          models.RoleCustomer,
+		EmailVerified: true,
+	}
+	if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+		t.Fatal("Error creating user:", err)
+	}
+
+	return user, createTestSession(t, user)
+}
+
+// createTestSession logs user in and returns the access token.
+func createTestSession(t *testing.T, user models.User) string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accessToken, _, err := generate.NewSession(ctx, user.Email, user.FirstName, user.LastName, user.UserID.Hex(), user.Role, false)
+	if err != nil {
+		t.Fatal("Error creating session:", err)
+	}
+	return accessToken
+}
+
+// createTestProduct inserts a product with the given price and stock.
+func createTestProduct(t *testing.T, name string, price float64, stock int) models.Product {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	product := models.Product{
+		ProductID:     primitive.NewObjectID(),
+		Name:          name,
+		NameLower:     strings.ToLower(name),
+		Price:         price,
+		StockQuantity: stock,
+		Images:        []string{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if _, err := ProductCollection.InsertOne(ctx, product); err != nil {
+		t.Fatal("Error creating product:", err)
+	}
+	return product
+}
+
+// serve sends a request with an optional JSON body through router. A
+// non-empty bearer is sent as the Authorization header.
+func serve(t *testing.T, router http.Handler, method string, path string, body interface{}, bearer string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+	switch body := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case []byte:
+		reader = bytes.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeResponse decodes the JSON body of w into v, failing the test unless
+// the response has the wanted status.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d, body: %s", w.Code, status, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("Error decoding response %s: %v", w.Body.String(), err)
+	}
+}
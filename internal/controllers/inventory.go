@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// runInTransaction executes fn inside a MongoDB multi-document transaction.
+// Everything fn writes through sessCtx is rolled back if it returns an error.
+// Transactions require MongoDB to run as a replica set.
+func runInTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := datasource.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// reserveStock decrements the stock of every ordered product. Each decrement
+// only applies while enough stock is left, so a short line fails the whole
+// transaction instead of driving stock negative. The units are counted as
+// sold for the popularity sort.
+func reserveStock(sessCtx mongo.SessionContext, items []models.OrderItem) error {
+	now := time.Now()
+	for _, item := range items {
+		result, err := ProductCollection.UpdateOne(sessCtx,
+			bson.M{"_id": item.ProductID, "stock_quantity": bson.M{"$gte": item.Quantity}},
+			bson.M{
+				"$inc": bson.M{"stock_quantity": -item.Quantity, "sold_count": item.Quantity},
+				"$set": bson.M{"updated_at": now},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return &orderError{http.StatusConflict, fmt.Sprintf("Not enough stock for %s", item.Name)}
+		}
+	}
+	return nil
+}
+
+// releaseStock puts the quantities of the given order lines back into stock
+// and takes them off the sold count.
+func releaseStock(sessCtx mongo.SessionContext, items []models.OrderItem) error {
+	now := time.Now()
+	for _, item := range items {
+		_, err := ProductCollection.UpdateOne(sessCtx,
+			bson.M{"_id": item.ProductID},
+			bson.M{
+				"$inc": bson.M{"stock_quantity": item.Quantity, "sold_count": -item.Quantity},
+				"$set": bson.M{"updated_at": now},
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdjustProductStock adds delta units to a product's stock, or takes them away
+// when delta is negative, e.g. after a delivery or a stock count. It uses $inc
+// like the order reservations, so neither overwrites the other, and never lets
+// the stock drop below zero.
+func AdjustProductStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("product_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var req struct {
+			Delta int `json:"delta" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delta must be a non-zero number of units"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"_id": objID}
+		if req.Delta < 0 {
+			filter["stock_quantity"] = bson.M{"$gte": -req.Delta}
+		}
+
+		var product models.Product
+		err = ProductCollection.FindOneAndUpdate(ctx, filter,
+			bson.M{
+				"$inc": bson.M{"stock_quantity": req.Delta},
+				"$set": bson.M{"updated_at": time.Now()},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&product)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Println("Error adjusting stock:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adjusting stock"})
+				return
+			}
+			// Tell a missing product apart from one without enough stock
+			count, err := ProductCollection.CountDocuments(ctx, bson.M{"_id": objID})
+			if err != nil {
+				log.Println("Error fetching product:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adjusting stock"})
+				return
+			}
+			if count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock to remove that many units"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"product_id": product.ProductID, "stock_quantity": product.StockQuantity})
+	}
+}
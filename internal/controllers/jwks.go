@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	generate "aevum-emporium-be/internal/token"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys our tokens can be verified with. Clients
+// may cache it briefly; a rotated key is listed before it signs any token.
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, generate.JWKS())
+	}
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var LoginThrottleCollection *mongo.Collection = datasource.LoginThrottleData(datasource.Client)
+var LoginAuditCollection *mongo.Collection = datasource.LoginAuditData(datasource.Client)
+
+// throttlePolicy says how many failures are tolerated before a key is locked
+// and how long the lock lasts. Each further failure doubles the lock, up to
+// maxLock.
+type throttlePolicy struct {
+	freeFailures int
+	baseLock     time.Duration
+	maxLock      time.Duration
+}
+
+var (
+	accountThrottlePolicy = throttlePolicy{freeFailures: 5, baseLock: time.Minute, maxLock: time.Hour}
+	ipThrottlePolicy      = throttlePolicy{freeFailures: 20, baseLock: time.Minute, maxLock: time.Hour}
+)
+
+// loginFailureWindow is how long failures are remembered after the last one.
+const loginFailureWindow = 24 * time.Hour
+
+// lockDuration returns how long a key with the given number of failures is locked.
+func (p throttlePolicy) lockDuration(failures int) time.Duration {
+	extra := failures - p.freeFailures
+	if extra <= 0 {
+		return 0
+	}
+	lock := p.baseLock
+	for i := 1; i < extra && lock < p.maxLock; i++ {
+		lock *= 2
+	}
+	if lock > p.maxLock {
+		lock = p.maxLock
+	}
+	return lock
+}
+
+type throttleKey struct {
+	key    string
+	policy throttlePolicy
+}
+
+func loginThrottleKeys(email string, ip string) []throttleKey {
+	return []throttleKey{
+		{"account:" + normalizeEmail(email), accountThrottlePolicy},
+		{"ip:" + ip, ipThrottlePolicy},
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLockRemaining returns how long logins for this account or from this IP
+// are still locked, or zero when they are allowed.
+func loginLockRemaining(ctx context.Context, email string, ip string) (time.Duration, error) {
+	keys := make([]string, 0, 2)
+	for _, k := range loginThrottleKeys(email, ip) {
+		keys = append(keys, k.key)
+	}
+
+	now := time.Now()
+	cursor, err := LoginThrottleCollection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}, "locked_until": bson.M{"$gt": now}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var throttles []models.LoginThrottle
+	if err := cursor.All(ctx, &throttles); err != nil {
+		return 0, err
+	}
+
+	var remaining time.Duration
+	for _, throttle := range throttles {
+		if d := throttle.LockedUntil.Sub(now); d > remaining {
+			remaining = d
+		}
+	}
+	return remaining, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP,
+// locking them once their policy is exceeded.
+func recordLoginFailure(ctx context.Context, email string, ip string) error {
+	now := time.Now()
+	for _, k := range loginThrottleKeys(email, ip) {
+		var throttle models.LoginThrottle
+		update := bson.M{
+			"$inc":         bson.M{"failures": 1},
+			"$set":         bson.M{"last_failed_at": now, "expires_at": now.Add(loginFailureWindow)},
+			"$setOnInsert": bson.M{"locked_until": time.Time{}},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := LoginThrottleCollection.FindOneAndUpdate(ctx, bson.M{"_id": k.key}, update, opts).Decode(&throttle)
+		if mongo.IsDuplicateKeyError(err) {
+			// A concurrent failure created the document first, update it instead
+			err = LoginThrottleCollection.FindOneAndUpdate(ctx, bson.M{"_id": k.key}, update, opts).Decode(&throttle)
+		}
+		if err != nil {
+			return err
+		}
+
+		if lock := k.policy.lockDuration(throttle.Failures); lock > 0 {
+			_, err := LoginThrottleCollection.UpdateOne(ctx, bson.M{"_id": k.key}, bson.M{"$set": bson.M{"locked_until": now.Add(lock)}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failures of an account after a successful
+// login. Failures counted against the IP are kept.
+func clearLoginFailures(ctx context.Context, email string) error {
+	_, err := LoginThrottleCollection.DeleteOne(ctx, bson.M{"_id": "account:" + normalizeEmail(email)})
+	return err
+}
+
+// auditLoginFailure stores an audit record of a failed or blocked login.
+func auditLoginFailure(ctx context.Context, email string, userID *primitive.ObjectID, ip string, userAgent string, reason string) error {
+	_, err := LoginAuditCollection.InsertOne(ctx, models.LoginAudit{
+		AuditID:   primitive.NewObjectID(),
+		Email:     normalizeEmail(email),
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/middleware"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"aevum-emporium-be/internal/totp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MFAIssuer is the name authenticator apps show next to the account.
+var MFAIssuer = getEnvOrDefault("MFA_ISSUER", "Aevum Emporium")
+
+const recoveryCodeCount = 10
+
+// newRecoveryCodes returns fresh recovery codes in the form "xxxxx-xxxxx"
+// along with the hashes that are stored.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, generate.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, a recovery code of
+// user. Accepted TOTP time steps are recorded and recovery codes removed, so
+// neither can be used twice.
+func checkSecondFactor(ctx context.Context, user models.User, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		counter, ok := totp.Validate(user.MFASecret, code, time.Now(), user.MFALastCounter)
+		if !ok {
+			return false, nil
+		}
+		// Only move the counter forward, a concurrent request may have
+		// used the same code already
+		result, err := UserCollection.UpdateOne(ctx,
+			bson.M{"_id": user.UserID, "mfa_last_counter": bson.M{"$not": bson.M{"$gte": counter}}},
+			bson.M{"$set": bson.M{"mfa_last_counter": counter}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount > 0, nil
+	}
+
+	if recoveryCode != "" {
+		hash := generate.HashToken(normalizeRecoveryCode(recoveryCode))
+		result, err := UserCollection.UpdateOne(ctx,
+			bson.M{"_id": user.UserID, "recovery_codes": hash},
+			bson.M{"$pull": bson.M{"recovery_codes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount > 0, nil
+	}
+
+	return false, nil
+}
+
+// EnrollMFA starts two-factor enrollment. It returns a new secret and the
+// provisioning URI to show as a QR code; the secret is only used once
+// ActivateMFA confirms a code generated from it.
+func EnrollMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Password string `json:"password" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if valid, _ := VerifyPassword(req.Password, user.Password); !valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+			return
+		}
+		if user.MFAEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			log.Println("Error generating MFA secret:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enrolling two-factor authentication"})
+			return
+		}
+
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{
+			"mfa_pending_secret": secret,
+			"updated_at":         time.Now(),
+		}})
+		if err != nil {
+			log.Println("Error saving MFA secret:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enrolling two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           secret,
+			"provisioning_uri": totp.ProvisioningURI(secret, MFAIssuer, user.Email),
+		})
+	}
+}
+
+// ActivateMFA turns on two-factor authentication once the user proves their
+// authenticator works. It returns the recovery codes, which are shown only
+// once, and logs the user out so they sign in again with the second factor.
+func ActivateMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if user.MFAEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		if user.MFAPendingSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+			return
+		}
+
+		counter, valid := totp.Validate(user.MFAPendingSecret, req.Code, time.Now(), 0)
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid authentication code"})
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println("Error generating recovery codes:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling two-factor authentication"})
+			return
+		}
+
+		result, err := UserCollection.UpdateOne(ctx,
+			bson.M{"_id": user.UserID, "mfa_pending_secret": user.MFAPendingSecret},
+			bson.M{
+				"$set": bson.M{
+					"mfa_enabled":      true,
+					"mfa_secret":       user.MFAPendingSecret,
+					"mfa_last_counter": counter,
+					"recovery_codes":   hashes,
+					"updated_at":       time.Now(),
+				},
+				"$unset": bson.M{"mfa_pending_secret": ""},
+			},
+		)
+		if err != nil {
+			log.Println("Error enabling MFA:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling two-factor authentication"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Enrollment changed, please start again"})
+			return
+		}
+
+		if err := generate.RevokeAllSessions(ctx, user.UserID, "two-factor authentication enabled"); err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication enabled, please log in again",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// VerifyMFA completes a login started by Login for a user with two-factor
+// authentication. It exchanges the challenge token and a TOTP or recovery
+// code for a token pair. Wrong codes count as failed logins.
+func VerifyMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			MFAToken     string `json:"mfa_token" validate:"required"`
+			Code         string `json:"code" validate:"required_without=RecoveryCode"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, err := generate.ValidateMFAChallengeToken(req.MFAToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token, please log in again"})
+			return
+		}
+		userObjectID, err := primitive.ObjectIDFromHex(claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token, please log in again"})
+			return
+		}
+
+		ip := c.ClientIP()
+		remaining, err := loginLockRemaining(ctx, claims.Email, ip)
+		if err != nil {
+			log.Println("Error checking login lock:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+		if remaining > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please try again later"})
+			return
+		}
+
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token, please log in again"})
+			return
+		}
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+			return
+		}
+
+		valid, err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			log.Println("Error checking second factor:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+		if !valid {
+			if err := recordLoginFailure(ctx, user.Email, ip); err != nil {
+				log.Println("Error recording login failure:", err)
+			}
+			if err := auditLoginFailure(ctx, user.Email, &user.UserID, ip, c.Request.UserAgent(), "wrong mfa code"); err != nil {
+				log.Println("Error auditing login:", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+			return
+		}
+
+		if err := clearLoginFailures(ctx, user.Email); err != nil {
+			log.Println("Error clearing login failures:", err)
+		}
+
+		respondWithSession(ctx, c, user, true)
+	}
+}
+
+// DisableMFA turns off two-factor authentication after checking the password
+// and a current code. Roles that require MFA cannot turn it off.
+func DisableMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Password     string `json:"password" validate:"required"`
+			Code         string `json:"code" validate:"required_without=RecoveryCode"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if !user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+			return
+		}
+		if middleware.MFARequired(user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+			return
+		}
+		if valid, _ := VerifyPassword(req.Password, user.Password); !valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+			return
+		}
+
+		valid, err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			log.Println("Error checking second factor:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error disabling two-factor authentication"})
+			return
+		}
+		if !valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+			return
+		}
+
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{
+			"$set":   bson.M{"mfa_enabled": false, "updated_at": time.Now()},
+			"$unset": bson.M{"mfa_secret": "", "mfa_last_counter": "", "recovery_codes": ""},
+		})
+		if err != nil {
+			log.Println("Error disabling MFA:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error disabling two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
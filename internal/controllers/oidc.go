@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"aevum-emporium-be/internal/oidc"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var OIDCLoginCollection *mongo.Collection = datasource.OIDCLoginData(datasource.Client)
+
+// OIDCProviders are the external identity providers users can sign in with,
+// configured by OIDC_PROVIDERS.
+var OIDCProviders = oidc.ProvidersFromEnv(AppBaseURL)
+
+// oidcLoginLifetime is how long the user has to complete a login at the provider.
+const oidcLoginLifetime = 10 * time.Minute
+
+var errOIDCEmailUnverified = errors.New("the provider did not confirm the email address")
+
+// findOrCreateOIDCUser returns the user linked to the external identity in
+// claims. An unlinked identity is linked to the user with the same verified
+// email, or to a new customer account when there is none.
+func findOrCreateOIDCUser(ctx context.Context, provider string, claims *oidc.Claims) (models.User, error) {
+	var user models.User
+	identityFilter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": claims.Subject}}}
+
+	err := UserCollection.FindOne(ctx, identityFilter).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	if claims.Email == "" || !claims.EmailVerified {
+		return user, errOIDCEmailUnverified
+	}
+
+	now := time.Now()
+	identity := models.Identity{Provider: provider, Subject: claims.Subject, LinkedAt: now}
+
+	err = UserCollection.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&user)
+	switch err {
+	case nil:
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{
+			"$push": bson.M{"identities": identity},
+			"$set":  bson.M{"email_verified": true, "updated_at": now},
+		})
+		user.Identities = append(user.Identities, identity)
+		user.EmailVerified = true
+	case mongo.ErrNoDocuments:
+		password, tokenErr := randomToken()
+		if tokenErr != nil {
+			return user, tokenErr
+		}
+		// The account has no usable password until the user sets one
+		// through the password reset flow
+		user = models.User{
+			UserID:        primitive.NewObjectID(),
+			FirstName:     claims.GivenName,
+			LastName:      claims.FamilyName,
+			Email:         claims.Email,
+			Password:      HashPassword(password),
+			Address:       make([]models.Address, 0),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			Role:          models.RoleCustomer,
+			EmailVerified: true,
+			Identities:    []models.Identity{identity},
+		}
+		_, err = UserCollection.InsertOne(ctx, user)
+	default:
+		return user, err
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent login linked the identity first
+		err = UserCollection.FindOne(ctx, identityFilter).Decode(&user)
+	}
+	return user, err
+}
+
+// OIDCLogin sends the user to the provider's sign-in page.
+func OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, ok := OIDCProviders[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			return
+		}
+
+		login := models.OIDCLogin{Provider: provider.Name}
+		var err error
+		if login.State, err = oidc.RandomString(); err == nil {
+			if login.Nonce, err = oidc.RandomString(); err == nil {
+				login.CodeVerifier, err = oidc.RandomString()
+			}
+		}
+		if err != nil {
+			log.Println("Error generating OIDC login:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting login"})
+			return
+		}
+
+		authURL, err := provider.AuthCodeURL(ctx, login.State, login.Nonce, login.CodeVerifier)
+		if err != nil {
+			log.Println("Error building OIDC authorization URL:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+			return
+		}
+
+		login.CreatedAt = time.Now()
+		login.ExpiresAt = login.CreatedAt.Add(oidcLoginLifetime)
+		if _, err := OIDCLoginCollection.InsertOne(ctx, login); err != nil {
+			log.Println("Error saving OIDC login:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting login"})
+			return
+		}
+
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback completes a login the provider redirected back from. It
+// answers like Login: with a token pair, or with an MFA challenge.
+func OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, ok := OIDCProviders[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			return
+		}
+
+		if c.Query("error") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Login was cancelled or denied at the provider"})
+			return
+		}
+
+		// Each login can only be completed once, and only with the provider it
+		// was started with
+		var login models.OIDCLogin
+		err := OIDCLoginCollection.FindOneAndDelete(ctx, bson.M{
+			"_id":        c.Query("state"),
+			"provider":   provider.Name,
+			"expires_at": bson.M{"$gt": time.Now()},
+		}).Decode(&login)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login, please try again"})
+				return
+			}
+			log.Println("Error fetching OIDC login:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, c.Query("code"), login.CodeVerifier, login.Nonce)
+		if err != nil {
+			log.Println("Error completing OIDC login:", err)
+			if errors.Is(err, oidc.ErrInvalidIDToken) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "The login provider's response could not be verified"})
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+			return
+		}
+
+		user, err := findOrCreateOIDCUser(ctx, provider.Name, claims)
+		if err != nil {
+			if err == errOIDCEmailUnverified {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Your email address is not verified with this provider"})
+				return
+			}
+			log.Println("Error linking OIDC identity:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging in"})
+			return
+		}
+
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+			return
+		}
+
+		completeLogin(ctx, c, user)
+	}
+}
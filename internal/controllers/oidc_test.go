@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/oidc"
+	"aevum-emporium-be/internal/oidc/oidctest"
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const testOIDCRedirectURL = "http://localhost:8080/auth/oidc/mock/callback"
+
+// useMockOIDC replaces the configured providers with a mock provider named
+// "mock" that signs everyone in as email.
+func useMockOIDC(t *testing.T, email string) {
+	t.Helper()
+
+	server, err := oidctest.NewServer(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	previous := OIDCProviders
+	OIDCProviders = map[string]*oidc.Provider{"mock": {
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "aevum",
+		ClientSecret: "secret",
+		RedirectURL:  testOIDCRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+	t.Cleanup(func() { OIDCProviders = previous })
+}
+
+func oidcTestRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/auth/oidc/:provider/login", OIDCLogin())
+	router.GET("/auth/oidc/:provider/callback", OIDCCallback())
+	return router
+}
+
+// startOIDCLogin starts a login and signs in at the provider, returning the
+// code and state the provider sent back.
+func startOIDCLogin(t *testing.T, router http.Handler) (string, string) {
+	t.Helper()
+
+	w := serve(t, router, http.MethodGet, "/auth/oidc/mock/login", nil, "")
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d, body: %s", w.Code, http.StatusFound, w.Body.String())
+	}
+	callback, err := oidctest.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func callbackPath(code string, state string) string {
+	return "/auth/oidc/mock/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+}
+
+func TestOIDCCallbackCreatesUser(t *testing.T) {
+	requireDatabase(t)
+	useMockOIDC(t, "social@example.com")
+	router := oidcTestRouter()
+
+	code, state := startOIDCLogin(t, router)
+
+	var login struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	w := serve(t, router, http.MethodGet, callbackPath(code, state), nil, "")
+	decodeResponse(t, w, http.StatusOK, &login)
+	if login.Token == "" || login.RefreshToken == "" {
+		t.Errorf("callback did not return a token pair: %s", w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var user bson.M
+	if err := UserCollection.FindOne(ctx, bson.M{"email": "social@example.com"}).Decode(&user); err != nil {
+		t.Fatal("Error fetching the new user:", err)
+	}
+	if user["email_verified"] != true {
+		t.Error("the provider verified email is not marked verified")
+	}
+	// An empty phone number would collide with every other social login
+	if _, ok := user["phone_number"]; ok {
+		t.Errorf("phone_number = %q, want it unset", user["phone_number"])
+	}
+
+	// The state is single-use
+	w = serve(t, router, http.MethodGet, callbackPath(code, state), nil, "")
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	requireDatabase(t)
+	useMockOIDC(t, "state@example.com")
+	router := oidcTestRouter()
+
+	code, state := startOIDCLogin(t, router)
+
+	for name, forged := range map[string]string{
+		"missing": "",
+		"unknown": "not-a-state-we-issued",
+	} {
+		w := serve(t, router, http.MethodGet, callbackPath(code, forged), nil, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s state: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// A login started with one provider cannot be completed with another
+	mock := OIDCProviders["mock"]
+	OIDCProviders["other"] = &oidc.Provider{
+		Name:         "other",
+		Issuer:       mock.Issuer,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  mock.RedirectURL,
+		Scopes:       mock.Scopes,
+	}
+	w := serve(t, router, http.MethodGet, "/auth/oidc/other/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil, "")
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+}
+
+func TestOIDCCallbackPKCEMismatch(t *testing.T) {
+	requireDatabase(t)
+	useMockOIDC(t, "pkce@example.com")
+	router := oidcTestRouter()
+
+	code, state := startOIDCLogin(t, router)
+
+	// Redeeming the code with a verifier other than the one the challenge was
+	// made from must fail at the provider
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := OIDCLoginCollection.UpdateOne(ctx, bson.M{"_id": state}, bson.M{"$set": bson.M{"code_verifier": "a-different-verifier"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(t, router, http.MethodGet, callbackPath(code, state), nil, "")
+	decodeResponse(t, w, http.StatusBadGateway, nil)
+
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"email": "pkce@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("a user was created although the login failed")
+	}
+}
+
+func TestOIDCUnknownProvider(t *testing.T) {
+	previous := OIDCProviders
+	OIDCProviders = map[string]*oidc.Provider{}
+	defer func() { OIDCProviders = previous }()
+
+	router := oidcTestRouter()
+	for _, path := range []string{"/auth/oidc/nope/login", "/auth/oidc/nope/callback?code=x&state=y"} {
+		if w := serve(t, router, http.MethodGet, path, nil, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
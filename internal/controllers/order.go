@@ -3,19 +3,290 @@ package controllers
 import (
 	"aevum-emporium-be/internal/datasource"
 	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
 	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v74"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var OrderCollection *mongo.Collection = datasource.OrderData(datasource.Client)
 
+// orderError is an order problem (pricing, stock, status) reported back to the client.
+type orderError struct {
+	Status  int
+	Message string
+}
+
+func (e *orderError) Error() string {
+	return e.Message
+}
+
+// roundPrice rounds an amount to whole cents.
+func roundPrice(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// discountedPrice applies the product's percentage discount to its list price.
+func discountedPrice(product models.Product) float64 {
+	if product.Discount == nil || *product.Discount <= 0 {
+		return product.Price
+	}
+	discount := math.Min(*product.Discount, 100)
+	return roundPrice(product.Price * (100 - discount) / 100)
+}
+
+// priceCartItems re-prices cart items from the product catalog, ignoring any
+// price stored on the cart, and checks each line against available stock.
+// It returns the order lines, the order total and the total discount applied.
+func priceCartItems(ctx context.Context, items []models.CartItem) ([]models.OrderItem, float64, float64, error) {
+	if len(items) == 0 {
+		return nil, 0, 0, &orderError{http.StatusBadRequest, "Cart is empty"}
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	cursor, err := ProductCollection.Find(ctx, bson.M{"_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, 0, 0, err
+	}
+
+	productsByID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ProductID] = product
+	}
+
+	orderItems := make([]models.OrderItem, 0, len(items))
+	var total, discount float64
+	for _, item := range items {
+		product, ok := productsByID[item.ProductID]
+		if !ok {
+			return nil, 0, 0, &orderError{http.StatusBadRequest, fmt.Sprintf("Product %s is no longer available", item.ProductID.Hex())}
+		}
+		if item.Quantity <= 0 {
+			return nil, 0, 0, &orderError{http.StatusBadRequest, fmt.Sprintf("Invalid quantity for %s", product.Name)}
+		}
+		if product.StockQuantity < item.Quantity {
+			return nil, 0, 0, &orderError{http.StatusConflict, fmt.Sprintf("Not enough stock for %s", product.Name)}
+		}
+
+		price := discountedPrice(product)
+		orderItems = append(orderItems, models.OrderItem{
+			ProductID: product.ProductID,
+			Name:      product.Name,
+			Quantity:  item.Quantity,
+			Price:     price,
+		})
+		total += price * float64(item.Quantity)
+		discount += (product.Price - price) * float64(item.Quantity)
+	}
+
+	return orderItems, roundPrice(total), roundPrice(discount), nil
+}
+
+// respondOrderError writes err to the client, replacing unexpected errors with fallback.
+func respondOrderError(c *gin.Context, err error, fallback string) {
+	var orderErr *orderError
+	if errors.As(err, &orderErr) {
+		c.JSON(orderErr.Status, gin.H{"error": orderErr.Message})
+		return
+	}
+	log.Println(fallback+":", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
+
+// newStatusHistory starts the status history of an order placed by
+// placedBy, which is empty for guest orders.
+func newStatusHistory(placedBy statusActor) []models.OrderStatusChange {
+	return []models.OrderStatusChange{{
+		Status:    models.OrderStatusPendingPayment,
+		ChangedBy: placedBy.userID,
+		ChangedAt: time.Now(),
+	}}
+}
+
+// newOrderNumber returns a short random order number customers can quote,
+// such as "AE-K3M9QX2T7B".
+func newOrderNumber() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "AE-" + base32.StdEncoding.EncodeToString(b)[:10], nil
+}
+
+// createOrderFromCart reserves stock for the order, inserts it and empties
+// the cart it was made from as a single unit. Guest carts are deleted
+// instead, their token is of no further use.
+func createOrderFromCart(ctx context.Context, cart models.Cart, order models.Order) error {
+	return runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := reserveStock(sessCtx, order.Items); err != nil {
+			return err
+		}
+		if _, err := OrderCollection.InsertOne(sessCtx, order); err != nil {
+			return err
+		}
+		if cart.UserID.IsZero() {
+			_, err := CartCollection.DeleteOne(sessCtx, bson.M{"_id": cart.CartID})
+			return err
+		}
+		_, err := CartCollection.UpdateOne(sessCtx, bson.M{"_id": cart.CartID}, bson.M{"$set": bson.M{"items": []models.CartItem{}, "total": 0}})
+		return err
+	})
+}
+
+// statusActor is who changes an order's status: a user, an API key, or
+// neither for system changes such as payment webhooks.
+type statusActor struct {
+	userID   *primitive.ObjectID
+	apiKeyID *primitive.ObjectID
+}
+
+// requestActor returns the user or API key the request is authenticated as.
+func requestActor(c *gin.Context) (statusActor, bool) {
+	if apiKeyID, err := primitive.ObjectIDFromHex(c.GetString("api_key_id")); err == nil {
+		return statusActor{apiKeyID: &apiKeyID}, true
+	}
+	if userID, err := primitive.ObjectIDFromHex(c.GetString("uid")); err == nil {
+		return statusActor{userID: &userID}, true
+	}
+	return statusActor{}, false
+}
+
+// transitionOrder moves an order to status and appends the change to its
+// history. The update only applies while the order still has the status it
+// was loaded with, so concurrent requests cannot bypass the transition table.
+func transitionOrder(ctx context.Context, order *models.Order, status string, changedBy statusActor, reason string) error {
+	if !models.CanTransitionOrder(order.Status, status) {
+		return &orderError{http.StatusConflict, fmt.Sprintf("Cannot change order status from %q to %q", order.Status, status)}
+	}
+
+	change := models.OrderStatusChange{
+		From:            order.Status,
+		Status:          status,
+		ChangedBy:       changedBy.userID,
+		ChangedByAPIKey: changedBy.apiKeyID,
+		ChangedAt:       time.Now(),
+		Reason:          reason,
+	}
+	result, err := OrderCollection.UpdateOne(ctx,
+		bson.M{"_id": order.OrderID, "status": order.Status},
+		bson.M{
+			"$set":  bson.M{"status": status},
+			"$push": bson.M{"status_history": change},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &orderError{http.StatusConflict, "Order status was changed by another request"}
+	}
+
+	order.Status = status
+	order.StatusHistory = append(order.StatusHistory, change)
+	return nil
+}
+
+// Checkout turns the authenticated user's cart into an order. Prices, names
+// and totals are taken from the product catalog, never from the client.
+func Checkout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Ensure user is authenticated
+		userID := c.GetString("uid")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		userObjectID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !requireVerifiedEmail(ctx, c, userObjectID, "checkout") {
+			return
+		}
+		if !requirePhoneNumber(ctx, c, userObjectID) {
+			return
+		}
+
+		var cart models.Cart
+		err = CartCollection.FindOne(ctx, bson.M{"user_id": userObjectID}).Decode(&cart)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+				return
+			}
+			log.Println("Error fetching cart:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cart"})
+			return
+		}
+
+		items, total, discount, err := priceCartItems(ctx, cart.Items)
+		if err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
+		}
+
+		orderNumber, err := newOrderNumber()
+		if err != nil {
+			log.Println("Error generating order number:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not place order"})
+			return
+		}
+
+		order := models.Order{
+			OrderID:       primitive.NewObjectID(),
+			OrderNumber:   orderNumber,
+			UserID:        userObjectID,
+			Items:         items,
+			TotalPrice:    total,
+			Discount:      &discount,
+			OrderedAt:     time.Now(),
+			Status:        models.OrderStatusPendingPayment,
+			StatusHistory: newStatusHistory(statusActor{userID: &userObjectID}),
+			PaymentStatus: models.PaymentStatusPending,
+		}
+
+		if err := createOrderFromCart(ctx, cart, order); err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Order placed successfully", "order": order})
+	}
+}
+
+// PlaceOrder creates an order for the items in the request body without
+// going through the cart. Only product IDs and quantities are read from the
+// client; names, prices and totals come from the product catalog as in Checkout.
 func PlaceOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Ensure user is authenticated
@@ -35,46 +306,74 @@ func PlaceOrder() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var order models.Order
-		if err := c.BindJSON(&order); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !requireVerifiedEmail(ctx, c, userObjectID, "checkout") {
+			return
+		}
+		if !requirePhoneNumber(ctx, c, userObjectID) {
 			return
 		}
 
-		// Ensure the order has the user ID and other necessary fields
-		order.UserID = userObjectID
-		order.OrderID = primitive.NewObjectID()
-		order.OrderedAt = time.Now()
-		order.Status = "Processing" // Default status
+		var req struct {
+			Items []struct {
+				ProductID primitive.ObjectID `json:"product_id"`
+				Quantity  int                `json:"quantity"`
+			} `json:"items"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// Ensure items exist
-		if len(order.Items) == 0 {
+		if len(req.Items) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Order must contain at least one item"})
 			return
 		}
-
-		// Calculate the total price if it's not already provided
-		if order.TotalPrice == 0 {
-			order.TotalPrice = 0
-			for _, item := range order.Items {
-				order.TotalPrice += item.Price * float64(item.Quantity)
-			}
+		requested := make([]models.CartItem, 0, len(req.Items))
+		for _, item := range req.Items {
+			requested = append(requested, models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity})
 		}
 
-		// Set discount to zero if it's not provided
-		if order.Discount == nil {
-			var zero float64
-			order.Discount = &zero
+		items, total, discount, err := priceCartItems(ctx, requested)
+		if err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
 		}
 
-		// Insert the order into the database
-		_, err = OrderCollection.InsertOne(ctx, order)
+		orderNumber, err := newOrderNumber()
 		if err != nil {
+			log.Println("Error generating order number:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not place order"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Order placed successfully", "order_id": order.OrderID})
+		order := models.Order{
+			OrderID:       primitive.NewObjectID(),
+			OrderNumber:   orderNumber,
+			UserID:        userObjectID,
+			Items:         items,
+			TotalPrice:    total,
+			Discount:      &discount,
+			OrderedAt:     time.Now(),
+			Status:        models.OrderStatusPendingPayment,
+			StatusHistory: newStatusHistory(statusActor{userID: &userObjectID}),
+			PaymentStatus: models.PaymentStatusPending,
+		}
+
+		// Insert the order and take its items out of stock atomically
+		err = runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			if err := reserveStock(sessCtx, order.Items); err != nil {
+				return err
+			}
+			_, err := OrderCollection.InsertOne(sessCtx, order)
+			return err
+		})
+		if err != nil {
+			respondOrderError(c, err, "Could not place order")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Order placed successfully", "order_id": order.OrderID, "order": order})
 	}
 }
 
@@ -125,23 +424,49 @@ func GetOrders() gin.HandlerFunc {
 	}
 }
 
-func UpdateOrder() gin.HandlerFunc {
+// ListAllOrders returns the orders of every customer, newest first,
+// optionally filtered by ?status=. It is meant for staff and integrations.
+func ListAllOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Ensure user is authenticated
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			if !models.IsOrderStatus(status) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status value"})
+				return
+			}
+			filter["status"] = status
 		}
 
-		// Check if the user is an admin
-		isAdmin, err := isAdmin(userID)
+		orders := make([]models.Order, 0)
+		cursor, err := OrderCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "ordered_at", Value: -1}}))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user role"})
+			log.Println("Error fetching orders:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching orders"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		if err := cursor.All(ctx, &orders); err != nil {
+			log.Println("Error decoding orders:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding orders"})
 			return
 		}
-		if !isAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can update the order status"})
+
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// UpdateOrder changes an order's status. Staff and integrations with an API
+// key can call it; the change is attributed to whichever made it.
+func UpdateOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Ensure the caller is authenticated
+		actor, ok := requestActor(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			return
 		}
 
@@ -158,74 +483,273 @@ func UpdateOrder() gin.HandlerFunc {
 
 		var updateData struct {
 			Status string `json:"status"` // New status
+			Reason string `json:"reason"`
 		}
 		if err := c.BindJSON(&updateData); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Ensure status is valid (e.g., "Shipping", "Delivered", etc.)
+		// Ensure status is one of the known lifecycle statuses
 		if updateData.Status == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Status cannot be empty"})
 			return
 		}
-		if updateData.Status != "Processing" && updateData.Status != "Shipping" && updateData.Status != "Delivered" {
+		if !models.IsOrderStatus(updateData.Status) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status value"})
 			return
 		}
 
-		// Update the order status in the database
-		update := bson.M{
-			"$set": bson.M{
-				"status": updateData.Status,
-			},
+		var order models.Order
+		err = OrderCollection.FindOne(ctx, bson.M{"_id": orderObjectID}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				return
+			}
+			log.Println("Error fetching order:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching order"})
+			return
+		}
+
+		// Only transitions allowed by the order lifecycle are applied, and
+		// statuses that move money or stock go through the code that does so
+		switch updateData.Status {
+		case models.OrderStatusCancelled:
+			err = cancelOrder(ctx, &order, actor, updateData.Reason)
+		case models.OrderStatusPaid:
+			err = &orderError{http.StatusConflict, "Orders are marked paid when their payment succeeds"}
+		case models.OrderStatusRefunded:
+			err = refundOrder(ctx, &order, actor, updateData.Reason)
+		case models.OrderStatusReturned:
+			err = returnOrder(ctx, &order, actor, updateData.Reason)
+		default:
+			err = transitionOrder(ctx, &order, updateData.Status, actor, updateData.Reason)
 		}
-		_, err = OrderCollection.UpdateOne(ctx, bson.M{"_id": orderObjectID}, update)
 		if err != nil {
-			log.Println("Error updating order:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating order"})
+			respondOrderError(c, err, "Error updating order")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Order status updated successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Order status updated successfully", "order": order})
 	}
 }
 
-func CancelOrder() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Ensure user is authenticated
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
+// cancelOrder cancels an order that has not shipped yet. In one transaction it
+// records the transition and puts the items back into stock; once that is
+// committed the payment is refunded, or cancelled if it is still open. Calling
+// it again for a cancelled order that is still paid retries the refund.
+func cancelOrder(ctx context.Context, order *models.Order, changedBy statusActor, reason string) error {
+	retryingRefund := order.Status == models.OrderStatusCancelled && order.PaymentStatus == models.PaymentStatusSucceeded
+	if !retryingRefund {
+		if !models.CanTransitionOrder(order.Status, models.OrderStatusCancelled) {
+			return &orderError{http.StatusConflict, fmt.Sprintf("Orders cannot be cancelled once they are %s", order.Status)}
 		}
 
-		// Convert the userID to ObjectID
+		var cancelled models.Order
+		err := runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// Work on a copy so a retried transaction starts from the loaded state
+			cancelled = *order
+			if err := transitionOrder(sessCtx, &cancelled, models.OrderStatusCancelled, changedBy, reason); err != nil {
+				return err
+			}
+			return releaseStock(sessCtx, cancelled.Items)
+		})
+		if err != nil {
+			return err
+		}
+		*order = cancelled
+	}
+
+	return settleCancelledPayment(ctx, order)
+}
+
+// refundOrder refunds a paid order in full through Stripe and then marks it
+// refunded. The refund is issued first and with a fixed idempotency key, so
+// when recording it fails, refunding again does not pay the customer twice.
+func refundOrder(ctx context.Context, order *models.Order, changedBy statusActor, reason string) error {
+	if !models.CanTransitionOrder(order.Status, models.OrderStatusRefunded) {
+		return &orderError{http.StatusConflict, fmt.Sprintf("Cannot change order status from %q to %q", order.Status, models.OrderStatusRefunded)}
+	}
+
+	refunded := false
+	switch order.PaymentStatus {
+	case models.PaymentStatusSucceeded:
+		refund, err := refundPayment(order.TransactionID, "refund-"+order.OrderID.Hex())
+		if err != nil {
+			log.Println("Error refunding payment:", err)
+			return &orderError{http.StatusBadGateway, "Error refunding the payment, please try again"}
+		}
+		// Pending refunds are settled by the charge.refunded webhook
+		refunded = refund.Status == stripe.RefundStatusSucceeded
+	case models.PaymentStatusRefunded:
+		// Refunded before, only the status is left to record
+	default:
+		return &orderError{http.StatusConflict, "Only paid orders can be refunded"}
+	}
+
+	if err := markOrderRefunded(ctx, order, changedBy, reason); err != nil {
+		return err
+	}
+	if !refunded {
+		return nil
+	}
+	order.PaymentStatus = models.PaymentStatusRefunded
+	return recordPaymentResult(ctx, order.TransactionID, models.PaymentStatusRefunded, "")
+}
+
+// markOrderRefunded moves an order to Refunded. Items of an order that never
+// shipped go back into stock in the same transaction.
+func markOrderRefunded(ctx context.Context, order *models.Order, changedBy statusActor, reason string) error {
+	restock := order.Status == models.OrderStatusPaid || order.Status == models.OrderStatusProcessing
+
+	var refunded models.Order
+	err := runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		refunded = *order
+		if err := transitionOrder(sessCtx, &refunded, models.OrderStatusRefunded, changedBy, reason); err != nil {
+			return err
+		}
+		if !restock {
+			return nil
+		}
+		return releaseStock(sessCtx, refunded.Items)
+	})
+	if err != nil {
+		return err
+	}
+	*order = refunded
+	return nil
+}
+
+// returnOrder records that a shipped order came back and puts its items back
+// into stock in the same transaction.
+func returnOrder(ctx context.Context, order *models.Order, changedBy statusActor, reason string) error {
+	var returned models.Order
+	err := runInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		returned = *order
+		if err := transitionOrder(sessCtx, &returned, models.OrderStatusReturned, changedBy, reason); err != nil {
+			return err
+		}
+		return releaseStock(sessCtx, returned.Items)
+	})
+	if err != nil {
+		return err
+	}
+	*order = returned
+	return nil
+}
+
+// settleCancelledPayment makes sure the customer is not charged for a
+// cancelled order. It calls Stripe outside of any transaction, so the call is
+// neither repeated by a transaction retry nor left standing by a rollback.
+func settleCancelledPayment(ctx context.Context, order *models.Order) error {
+	if order.TransactionID == "" {
+		return nil
+	}
+
+	if order.PaymentStatus != models.PaymentStatusSucceeded {
+		// An open PaymentIntent could still be paid, cancel it
+		pi, err := stripeClient.PaymentIntents.Cancel(order.TransactionID, nil)
+		if err != nil {
+			// Stripe refuses to cancel intents that already succeeded or were cancelled
+			if pi, err = stripeClient.PaymentIntents.Get(order.TransactionID, nil); err != nil {
+				log.Println("Error cancelling payment intent:", err)
+				return &orderError{http.StatusBadGateway, "The order was cancelled but its payment could not be cancelled"}
+			}
+		}
+		if pi.Status != stripe.PaymentIntentStatusSucceeded {
+			order.PaymentStatus = models.PaymentStatusFailed
+			return recordPaymentResult(ctx, order.TransactionID, models.PaymentStatusFailed, "Order cancelled")
+		}
+		// Paid after all. Recording the success refunds the cancelled order,
+		// see advanceOrderForPayment
+		if err := recordPaymentResult(ctx, order.TransactionID, models.PaymentStatusSucceeded, ""); err != nil {
+			return err
+		}
+		return OrderCollection.FindOne(ctx, bson.M{"_id": order.OrderID}).Decode(order)
+	}
+
+	refund, err := refundPayment(order.TransactionID, "cancel-"+order.OrderID.Hex())
+	if err != nil {
+		log.Println("Error refunding payment:", err)
+		return &orderError{http.StatusBadGateway, "The order was cancelled but the refund failed, cancel it again to retry"}
+	}
+	if refund.Status == stripe.RefundStatusSucceeded {
+		order.PaymentStatus = models.PaymentStatusRefunded
+		return recordPaymentResult(ctx, order.TransactionID, models.PaymentStatusRefunded, "")
+	}
+	// Pending refunds are settled by the charge.refunded webhook
+	return nil
+}
+
+// findUserOrder loads an order by its ID, scoped to the authenticated user.
+// Without a user, a guest order can be loaded with its order token.
+func findUserOrder(ctx context.Context, c *gin.Context) (models.Order, bool) {
+	var order models.Order
+
+	orderObjectID, err := primitive.ObjectIDFromHex(c.Param("order_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return order, false
+	}
+
+	filter := bson.M{"_id": orderObjectID}
+	if userID := c.GetString("uid"); userID != "" {
 		userObjectID, err := primitive.ObjectIDFromHex(userID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
+			return order, false
+		}
+		filter["user_id"] = userObjectID
+	} else {
+		claims, err := generate.ValidateGuestOrderToken(c.GetHeader(OrderTokenHeader))
+		if err != nil || claims.Subject != orderObjectID.Hex() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return order, false
 		}
+		filter["user_id"] = primitive.NilObjectID
+	}
 
-		// Get the order ID from the URL
-		orderID := c.Param("order_id")
-		orderObjectID, err := primitive.ObjectIDFromHex(orderID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
-			return
+	err = OrderCollection.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return order, false
 		}
+		log.Println("Error fetching order:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching order"})
+		return order, false
+	}
+
+	return order, true
+}
 
+// CancelOrder lets a user cancel one of their own orders before it ships.
+// The order is kept with status "Cancelled" rather than deleted.
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		// Delete the order from the database
-		_, err = OrderCollection.DeleteOne(ctx, bson.M{"_id": orderObjectID, "user_id": userObjectID})
-		if err != nil {
-			log.Println("Error deleting order:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting order"})
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		// The reason is optional, so an empty body is fine
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		order, ok := findUserOrder(ctx, c)
+		if !ok {
+			return
+		}
+
+		if err := cancelOrder(ctx, &order, statusActor{userID: &order.UserID}, req.Reason); err != nil {
+			respondOrderError(c, err, "Error cancelling order")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Order deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully", "order": order})
 	}
 }
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/middleware"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func orderTestRouter() *gin.Engine {
+	router := paymentTestRouter()
+	router.PUT("/orders/:order_id/status", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionOrdersManage), UpdateOrder())
+	return router
+}
+
+// createTestStaff creates a user with role and returns an access token of theirs.
+func createTestStaff(t *testing.T, email string, role string) string {
+	t.Helper()
+
+	user, _ := createTestUser(t, email)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{"role": role}}); err != nil {
+		t.Fatal(err)
+	}
+	user.Role = role
+	return createTestSession(t, user)
+}
+
+func productStock(t *testing.T, productID primitive.ObjectID) int {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	if err := ProductCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		t.Fatal(err)
+	}
+	return product.StockQuantity
+}
+
+// payTestOrder pays an order with a card that is accepted.
+func payTestOrder(t *testing.T, router http.Handler, accessToken string, order models.Order) {
+	t.Helper()
+
+	w := serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, nil)
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/confirm", gin.H{"payment_method": "pm_card_visa"}, accessToken)
+	decodeResponse(t, w, http.StatusOK, nil)
+}
+
+func setTestOrderStatus(t *testing.T, router http.Handler, staffToken string, orderID primitive.ObjectID, status string, want int) {
+	t.Helper()
+
+	w := serve(t, router, http.MethodPut, "/orders/"+orderID.Hex()+"/status", gin.H{"status": status}, staffToken)
+	decodeResponse(t, w, want, nil)
+}
+
+func TestUpdateOrderCannotMarkPaid(t *testing.T) {
+	requireDatabase(t)
+	useStripeStub(t)
+	router := orderTestRouter()
+
+	_, accessToken := createTestUser(t, "unpaid@example.com")
+	staffToken := createTestStaff(t, "orders-paid@example.com", models.RoleOrderManager)
+	product := createTestProduct(t, "Hourglass", 45, 4)
+	order := checkoutTestOrder(t, router, accessToken, product, 1)
+
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusPaid, http.StatusConflict)
+
+	// The customer can still pay
+	payTestOrder(t, router, accessToken, order)
+}
+
+func TestUpdateOrderRefundsPayment(t *testing.T) {
+	requireDatabase(t)
+	stub := useStripeStub(t)
+	router := orderTestRouter()
+
+	_, accessToken := createTestUser(t, "refunded@example.com")
+	staffToken := createTestStaff(t, "orders-refund@example.com", models.RoleOrderManager)
+	product := createTestProduct(t, "Barometer", 95, 5)
+	order := checkoutTestOrder(t, router, accessToken, product, 2)
+
+	// Only paid orders can be refunded
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusRefunded, http.StatusConflict)
+
+	payTestOrder(t, router, accessToken, order)
+	paid := findTestOrder(t, order.OrderID)
+
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusRefunded, http.StatusOK)
+
+	refunded := findTestOrder(t, order.OrderID)
+	if refunded.Status != models.OrderStatusRefunded || refunded.PaymentStatus != models.PaymentStatusRefunded {
+		t.Errorf("order is %q with payment %q, want %q with %q", refunded.Status, refunded.PaymentStatus, models.OrderStatusRefunded, models.PaymentStatusRefunded)
+	}
+	if refunds := stub.refunded(); len(refunds) != 1 || refunds[0] != paid.TransactionID {
+		t.Errorf("refunds = %v, want one of %s", refunds, paid.TransactionID)
+	}
+	// Nothing shipped, so everything is back in stock
+	if stock := productStock(t, product.ProductID); stock != 5 {
+		t.Errorf("stock = %d, want 5", stock)
+	}
+}
+
+func TestUpdateOrderReturnRestocks(t *testing.T) {
+	requireDatabase(t)
+	stub := useStripeStub(t)
+	router := orderTestRouter()
+
+	_, accessToken := createTestUser(t, "returned@example.com")
+	staffToken := createTestStaff(t, "orders-return@example.com", models.RoleOrderManager)
+	product := createTestProduct(t, "Astrolabe", 210, 3)
+	order := checkoutTestOrder(t, router, accessToken, product, 2)
+	payTestOrder(t, router, accessToken, order)
+
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusProcessing, http.StatusOK)
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusShipped, http.StatusOK)
+	if stock := productStock(t, product.ProductID); stock != 1 {
+		t.Fatalf("stock after shipping = %d, want 1", stock)
+	}
+
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusReturned, http.StatusOK)
+	if stock := productStock(t, product.ProductID); stock != 3 {
+		t.Errorf("stock after the return = %d, want 3", stock)
+	}
+
+	// Refunding the returned order does not restock a second time
+	setTestOrderStatus(t, router, staffToken, order.OrderID, models.OrderStatusRefunded, http.StatusOK)
+	if stock := productStock(t, product.ProductID); stock != 3 {
+		t.Errorf("stock after the refund = %d, want 3", stock)
+	}
+	if refunds := stub.refunded(); len(refunds) != 1 {
+		t.Errorf("refunds = %v, want one", refunds)
+	}
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/mailer"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PasswordResetCollection *mongo.Collection = datasource.PasswordResetData(datasource.Client)
+
+// PasswordResetURL is the front-end page reset links point to; the token is
+// appended as ?token=
+var PasswordResetURL = getEnvOrDefault("PASSWORD_RESET_URL", AppBaseURL+"/reset-password")
+
+const passwordResetLifetime = time.Hour
+
+// randomToken returns a URL-safe random token with 256 bits of entropy.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sendPasswordResetEmail replaces any outstanding reset token of the user
+// with a new one and emails it.
+func sendPasswordResetEmail(ctx context.Context, user models.User) error {
+	resetToken, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	if _, err := PasswordResetCollection.DeleteMany(ctx, bson.M{"user_id": user.UserID}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = PasswordResetCollection.InsertOne(ctx, models.PasswordReset{
+		ResetID:   primitive.NewObjectID(),
+		UserID:    user.UserID,
+		TokenHash: generate.HashToken(resetToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := PasswordResetURL + "?token=" + url.QueryEscape(resetToken)
+	return Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open the link below:\n\n%s\n\nThe link expires in one hour. If you did not ask for this, you can ignore this email.\n",
+			user.FirstName, link),
+	})
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the email belongs to an account.
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Do the work in the background so response times don't reveal
+		// whether the account exists
+		go func(email string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+
+			var user models.User
+			if err := UserCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user); err != nil {
+				if err != mongo.ErrNoDocuments {
+					log.Println("Error fetching user for password reset:", err)
+				}
+				return
+			}
+			if user.Disabled {
+				return
+			}
+
+			if err := sendPasswordResetEmail(ctx, user); err != nil {
+				log.Println("Error sending password reset email:", err)
+			}
+		}(req.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
+	}
+}
+
+// ResetPassword sets a new password using a reset token and logs the user
+// out of every session.
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required,min=8"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Claim the token atomically so it can only be used once
+		now := time.Now()
+		var reset models.PasswordReset
+		err := PasswordResetCollection.FindOneAndUpdate(ctx,
+			bson.M{
+				"token_hash": generate.HashToken(req.Token),
+				"used_at":    bson.M{"$exists": false},
+				"expires_at": bson.M{"$gt": now},
+			},
+			bson.M{"$set": bson.M{"used_at": now}},
+		).Decode(&reset)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+				return
+			}
+			log.Println("Error redeeming reset token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+			return
+		}
+
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": reset.UserID}, bson.M{"$set": bson.M{
+			"password":   HashPassword(req.Password),
+			"updated_at": now,
+		}})
+		if err != nil {
+			log.Println("Error updating password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+			return
+		}
+
+		if err := generate.RevokeAllSessions(ctx, reset.UserID, "password reset"); err != nil {
+			log.Println("Error revoking sessions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+	}
+}
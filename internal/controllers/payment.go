@@ -1 +1,444 @@
 package controllers
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/client"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PaymentCollection *mongo.Collection = datasource.PaymentData(datasource.Client)
+var PaymentEventCollection *mongo.Collection = datasource.PaymentEventData(datasource.Client)
+
+// StripeWebhookSecret is the signing secret used to verify Stripe-Signature
+// headers. Without it StripeWebhook refuses every event, since any signature
+// would check out against an empty key.
+var StripeWebhookSecret = os.Getenv("STRIPE_WEBHOOK_SECRET")
+
+// StripeCurrency is the ISO currency code all PaymentIntents are created in.
+var StripeCurrency = getEnvOrDefault("STRIPE_CURRENCY", "usd")
+
+var stripeClient = newStripeClient()
+
+// newStripeClient builds the Stripe API client from STRIPE_SECRET_KEY. When
+// STRIPE_API_BASE is set, requests go to that URL instead of api.stripe.com,
+// which lets the payment flow run against a local stub server.
+func newStripeClient() *client.API {
+	backends := stripe.NewBackends(nil)
+	if baseURL := os.Getenv("STRIPE_API_BASE"); baseURL != "" {
+		backends.API = stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+			URL: stripe.String(baseURL),
+		})
+	}
+	return client.New(os.Getenv("STRIPE_SECRET_KEY"), backends)
+}
+
+func getEnvOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// toMinorUnits converts an order amount to the smallest currency unit Stripe expects.
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// paymentStatusFromIntent maps a Stripe PaymentIntent status onto our payment statuses.
+func paymentStatusFromIntent(pi *stripe.PaymentIntent) string {
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return models.PaymentStatusSucceeded
+	case stripe.PaymentIntentStatusCanceled:
+		return models.PaymentStatusFailed
+	case stripe.PaymentIntentStatusRequiresPaymentMethod:
+		// A failed attempt sends the PaymentIntent back to requires_payment_method
+		if pi.LastPaymentError != nil {
+			return models.PaymentStatusFailed
+		}
+	}
+	return models.PaymentStatusPending
+}
+
+// settledPaymentStatuses lists, for each outcome, the statuses it must not
+// overwrite. Stripe does not guarantee event ordering, so a late failure
+// notification must never undo a success and nothing but a refund may follow one.
+var settledPaymentStatuses = map[string][]string{
+	models.PaymentStatusPending:   {models.PaymentStatusSucceeded, models.PaymentStatusRefunded},
+	models.PaymentStatusSucceeded: {models.PaymentStatusRefunded},
+	models.PaymentStatusFailed:    {models.PaymentStatusSucceeded, models.PaymentStatusRefunded},
+	models.PaymentStatusRefunded:  {},
+}
+
+// recordPaymentResult stores the outcome of a PaymentIntent on both the
+// Payment document and the Order it belongs to.
+func recordPaymentResult(ctx context.Context, transactionID string, status string, failureMessage string) error {
+	paymentUpdate := bson.M{
+		"status":          status,
+		"failure_message": failureMessage,
+	}
+	if status == models.PaymentStatusSucceeded {
+		paymentUpdate["paid_at"] = time.Now()
+	}
+
+	settled := settledPaymentStatuses[status]
+	_, err := PaymentCollection.UpdateOne(ctx,
+		bson.M{"transaction_id": transactionID, "status": bson.M{"$nin": settled}},
+		bson.M{"$set": paymentUpdate},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = OrderCollection.UpdateOne(ctx,
+		bson.M{"transaction_id": transactionID, "payment_status": bson.M{"$nin": settled}},
+		bson.M{"$set": bson.M{
+			"payment_status":        status,
+			"payment_method.status": status,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case models.PaymentStatusSucceeded:
+		return advanceOrderForPayment(ctx, transactionID, models.OrderStatusPaid, "Payment succeeded")
+	case models.PaymentStatusRefunded:
+		return advanceOrderForPayment(ctx, transactionID, models.OrderStatusRefunded, "Payment refunded")
+	}
+	return nil
+}
+
+// advanceOrderForPayment moves the order paid by transactionID to status when
+// its lifecycle allows it. Orders that have already moved on are left alone.
+func advanceOrderForPayment(ctx context.Context, transactionID string, status string, reason string) error {
+	var order models.Order
+	err := OrderCollection.FindOne(ctx, bson.M{"transaction_id": transactionID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	if status == models.OrderStatusPaid && order.Status == models.OrderStatusCancelled && order.PaymentStatus == models.PaymentStatusSucceeded {
+		// The payment went through after the order was cancelled, e.g. while
+		// its PaymentIntent was processing and could not be cancelled
+		return settleCancelledPayment(ctx, &order)
+	}
+	if !models.CanTransitionOrder(order.Status, status) {
+		return nil
+	}
+	if status == models.OrderStatusRefunded {
+		return markOrderRefunded(ctx, &order, statusActor{}, reason)
+	}
+	return transitionOrder(ctx, &order, status, statusActor{}, reason)
+}
+
+// requirePayableOrder checks that order is still waiting to be paid. Paid
+// orders must not be charged twice, and cancelled ones have already given
+// their stock back. It returns false after answering the request otherwise.
+func requirePayableOrder(c *gin.Context, order models.Order) bool {
+	if order.PaymentStatus == models.PaymentStatusSucceeded {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has already been paid"})
+		return false
+	}
+	if order.Status != models.OrderStatusPendingPayment {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Orders cannot be paid once they are %s", order.Status)})
+		return false
+	}
+	return true
+}
+
+// CreatePaymentIntent creates (or reuses) a Stripe PaymentIntent for one of the user's orders
+func CreatePaymentIntent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, ok := findUserOrder(ctx, c)
+		if !ok {
+			return
+		}
+
+		if !requirePayableOrder(c, order) {
+			return
+		}
+
+		// Reuse the existing PaymentIntent unless Stripe has already given up on it
+		if order.TransactionID != "" {
+			pi, err := stripeClient.PaymentIntents.Get(order.TransactionID, nil)
+			if err != nil {
+				log.Println("Error fetching payment intent:", err)
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Error contacting payment provider"})
+				return
+			}
+			if pi.Status != stripe.PaymentIntentStatusCanceled {
+				c.JSON(http.StatusOK, gin.H{
+					"payment_intent_id": pi.ID,
+					"client_secret":     pi.ClientSecret,
+					"status":            pi.Status,
+				})
+				return
+			}
+		}
+
+		params := &stripe.PaymentIntentParams{
+			Amount:             stripe.Int64(toMinorUnits(order.TotalPrice)),
+			Currency:           stripe.String(StripeCurrency),
+			PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		}
+		params.AddMetadata("order_id", order.OrderID.Hex())
+		params.AddMetadata("user_id", order.UserID.Hex())
+
+		pi, err := stripeClient.PaymentIntents.New(params)
+		if err != nil {
+			log.Println("Error creating payment intent:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Error contacting payment provider"})
+			return
+		}
+
+		payment := models.Payment{
+			PaymentID:     primitive.NewObjectID(),
+			OrderID:       order.OrderID,
+			UserID:        order.UserID,
+			TransactionID: pi.ID,
+			Method:        "Credit Card",
+			Status:        models.PaymentStatusPending,
+			Amount:        order.TotalPrice,
+			Currency:      StripeCurrency,
+			CreatedAt:     time.Now(),
+		}
+		if _, err := PaymentCollection.InsertOne(ctx, payment); err != nil {
+			log.Println("Error saving payment:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving payment"})
+			return
+		}
+
+		// The order may have been cancelled while the PaymentIntent was created
+		result, err := OrderCollection.UpdateOne(ctx,
+			bson.M{"_id": order.OrderID, "status": models.OrderStatusPendingPayment},
+			bson.M{"$set": bson.M{
+				"transaction_id": pi.ID,
+				"payment_status": models.PaymentStatusPending,
+				"payment_method": payment,
+			}},
+		)
+		if err != nil {
+			log.Println("Error updating order payment:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating order"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			if _, err := stripeClient.PaymentIntents.Cancel(pi.ID, nil); err != nil {
+				log.Println("Error cancelling payment intent", pi.ID, ":", err)
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Order is no longer awaiting payment"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"payment_intent_id": pi.ID,
+			"client_secret":     pi.ClientSecret,
+			"status":            pi.Status,
+		})
+	}
+}
+
+// ConfirmPayment confirms the order's PaymentIntent with the given payment method
+// and records whether the charge succeeded or failed.
+func ConfirmPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			PaymentMethod string `json:"payment_method" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		order, ok := findUserOrder(ctx, c)
+		if !ok {
+			return
+		}
+
+		if !requirePayableOrder(c, order) {
+			return
+		}
+		if order.TransactionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No payment has been started for this order"})
+			return
+		}
+
+		pi, err := stripeClient.PaymentIntents.Confirm(order.TransactionID, &stripe.PaymentIntentConfirmParams{
+			PaymentMethod: stripe.String(req.PaymentMethod),
+		})
+		if err != nil {
+			// Card errors are a payment outcome, anything else is a provider failure
+			var stripeErr *stripe.Error
+			if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+				if err := recordPaymentResult(ctx, order.TransactionID, models.PaymentStatusFailed, stripeErr.Msg); err != nil {
+					log.Println("Error recording failed payment:", err)
+				}
+				c.JSON(http.StatusPaymentRequired, gin.H{
+					"error":          stripeErr.Msg,
+					"payment_status": models.PaymentStatusFailed,
+				})
+				return
+			}
+			log.Println("Error confirming payment intent:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Error contacting payment provider"})
+			return
+		}
+
+		status := paymentStatusFromIntent(pi)
+		failureMessage := ""
+		if pi.LastPaymentError != nil {
+			failureMessage = pi.LastPaymentError.Msg
+		}
+
+		if err := recordPaymentResult(ctx, pi.ID, status, failureMessage); err != nil {
+			log.Println("Error recording payment:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording payment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"payment_intent_id": pi.ID,
+			"status":            pi.Status,
+			"payment_status":    status,
+		})
+	}
+}
+
+// refundPayment refunds the PaymentIntent transactionID in full. The
+// idempotency key makes retries of the same refund safe.
+func refundPayment(transactionID string, idempotencyKey string) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(transactionID),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+	return stripeClient.Refunds.New(params)
+}
+
+// maxWebhookPayload caps the size of webhook bodies we are willing to read.
+const maxWebhookPayload = 65536
+
+// applyPaymentEvent reconciles the order referenced by a Stripe event.
+// Events we do not subscribe to are ignored.
+func applyPaymentEvent(ctx context.Context, event stripe.Event) error {
+	switch event.Type {
+	case "payment_intent.succeeded":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return err
+		}
+		return recordPaymentResult(ctx, pi.ID, models.PaymentStatusSucceeded, "")
+
+	case "payment_intent.payment_failed":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return err
+		}
+		failureMessage := ""
+		if pi.LastPaymentError != nil {
+			failureMessage = pi.LastPaymentError.Msg
+		}
+		return recordPaymentResult(ctx, pi.ID, models.PaymentStatusFailed, failureMessage)
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return err
+		}
+		// Partial refunds leave the order paid
+		if charge.PaymentIntent == nil || !charge.Refunded {
+			return nil
+		}
+		return recordPaymentResult(ctx, charge.PaymentIntent.ID, models.PaymentStatusRefunded, "")
+	}
+
+	return nil
+}
+
+// StripeWebhook verifies and processes Stripe webhook events. Each event ID is
+// recorded before it is applied so redelivered events are acknowledged without
+// being processed twice.
+func StripeWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if StripeWebhookSecret == "" {
+			log.Println("Rejected webhook event: STRIPE_WEBHOOK_SECRET is not set")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Webhooks are not configured"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookPayload))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading request body"})
+			return
+		}
+
+		event, err := webhook.ConstructEventWithOptions(payload, c.GetHeader("Stripe-Signature"), StripeWebhookSecret,
+			webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+			return
+		}
+
+		_, err = PaymentEventCollection.InsertOne(ctx, models.PaymentEvent{
+			EventID:    event.ID,
+			Type:       event.Type,
+			ReceivedAt: time.Now(),
+		})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusOK, gin.H{"message": "Event already processed"})
+				return
+			}
+			log.Println("Error recording webhook event:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording event"})
+			return
+		}
+
+		if err := applyPaymentEvent(ctx, event); err != nil {
+			log.Println("Error processing webhook event", event.ID, ":", err)
+			// Forget the event so Stripe's retry gets processed
+			if _, delErr := PaymentEventCollection.DeleteOne(ctx, bson.M{"_id": event.ID}); delErr != nil {
+				log.Println("Error removing webhook event:", delErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing event"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+	}
+}
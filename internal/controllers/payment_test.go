@@ -0,0 +1,432 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/middleware"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/client"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// stripeStub answers the PaymentIntent and Refund calls the payment handlers
+// make. Confirming with pm_card_visa succeeds, any other payment method is
+// declined.
+type stripeStub struct {
+	mu      sync.Mutex
+	next    int
+	intents map[string]map[string]interface{}
+	refunds []string
+}
+
+func (s *stripeStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "payment_intents":
+		s.next++
+		id := fmt.Sprintf("pi_test_%d", s.next)
+		var amount int64
+		fmt.Sscan(r.PostForm.Get("amount"), &amount)
+		s.intents[id] = map[string]interface{}{
+			"id":            id,
+			"object":        "payment_intent",
+			"amount":        amount,
+			"currency":      r.PostForm.Get("currency"),
+			"client_secret": id + "_secret",
+			"status":        "requires_payment_method",
+			"metadata":      map[string]string{"order_id": r.PostForm.Get("metadata[order_id]")},
+		}
+		writeStripeJSON(w, http.StatusOK, s.intents[id])
+
+	case len(path) >= 2 && path[0] == "payment_intents":
+		pi, ok := s.intents[path[1]]
+		if !ok {
+			writeStripeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{
+				"type": "invalid_request_error", "message": "No such payment_intent",
+			}})
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && len(path) == 2:
+		case r.Method == http.MethodPost && len(path) == 3 && path[2] == "confirm":
+			if r.PostForm.Get("payment_method") != "pm_card_visa" {
+				pi["last_payment_error"] = map[string]string{"type": "card_error", "message": "Your card was declined."}
+				writeStripeJSON(w, http.StatusPaymentRequired, map[string]interface{}{"error": map[string]interface{}{
+					"type": "card_error", "code": "card_declined", "message": "Your card was declined.", "payment_intent": pi,
+				}})
+				return
+			}
+			pi["status"] = "succeeded"
+			delete(pi, "last_payment_error")
+		case r.Method == http.MethodPost && len(path) == 3 && path[2] == "cancel":
+			if pi["status"] == "processing" {
+				writeStripeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": map[string]string{
+					"type": "invalid_request_error", "code": "payment_intent_unexpected_state", "message": "This PaymentIntent is processing.",
+				}})
+				return
+			}
+			pi["status"] = "canceled"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		writeStripeJSON(w, http.StatusOK, pi)
+
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "refunds":
+		s.refunds = append(s.refunds, r.PostForm.Get("payment_intent"))
+		writeStripeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":             fmt.Sprintf("re_test_%d", len(s.refunds)),
+			"object":         "refund",
+			"payment_intent": r.PostForm.Get("payment_intent"),
+			"status":         "succeeded",
+		})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// intentField returns a field of the PaymentIntent id as the stub last sent it.
+func (s *stripeStub) intentField(id string, field string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.intents[id][field]
+}
+
+// setIntentStatus changes the status of the PaymentIntent id, like an
+// asynchronous payment method would.
+func (s *stripeStub) setIntentStatus(id string, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.intents[id]["status"] = status
+}
+
+func (s *stripeStub) refunded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.refunds...)
+}
+
+func writeStripeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// useStripeStub points the Stripe client at a stub server and sets the
+// webhook secret for the duration of the test.
+func useStripeStub(t *testing.T) *stripeStub {
+	t.Helper()
+
+	stub := &stripeStub{intents: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	backends := stripe.NewBackends(nil)
+	backends.API = stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(server.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+	})
+
+	previousClient, previousSecret := stripeClient, StripeWebhookSecret
+	stripeClient = client.New("sk_test_stub", backends)
+	StripeWebhookSecret = testWebhookSecret
+	t.Cleanup(func() {
+		stripeClient, StripeWebhookSecret = previousClient, previousSecret
+	})
+	return stub
+}
+
+func paymentTestRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/cart/", middleware.AuthMiddleware(), AddToCart())
+	router.POST("/orders/checkout", middleware.AuthMiddleware(), Checkout())
+	router.POST("/orders/:order_id/cancel", middleware.AuthMiddleware(), CancelOrder())
+	router.POST("/payments/webhook", StripeWebhook())
+	router.POST("/payments/:order_id/intent", middleware.AuthMiddleware(), CreatePaymentIntent())
+	router.POST("/payments/:order_id/confirm", middleware.AuthMiddleware(), ConfirmPayment())
+	return router
+}
+
+// signedWebhookEvent returns an event payload of eventType about object and
+// its Stripe-Signature header.
+func signedWebhookEvent(t *testing.T, eventID string, eventType string, object interface{}) ([]byte, string) {
+	t.Helper()
+
+	raw, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          eventID,
+		"object":      "event",
+		"type":        eventType,
+		"api_version": stripe.APIVersion,
+		"data":        map[string]json.RawMessage{"object": raw},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: testWebhookSecret})
+	return signed.Payload, signed.Header
+}
+
+func postWebhook(router http.Handler, payload []byte, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set("Stripe-Signature", signature)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// checkoutTestOrder puts quantity units of product in the user's cart and
+// checks out, returning the new order.
+func checkoutTestOrder(t *testing.T, router http.Handler, accessToken string, product models.Product, quantity int) models.Order {
+	t.Helper()
+
+	// The price in the cart is the client's and must not be charged
+	w := serve(t, router, http.MethodPost, "/cart/", gin.H{"product_id": product.ProductID, "quantity": quantity, "price": 0.01}, accessToken)
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	var checkout struct {
+		Order models.Order `json:"order"`
+	}
+	w = serve(t, router, http.MethodPost, "/orders/checkout", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, &checkout)
+	return checkout.Order
+}
+
+func findTestOrder(t *testing.T, orderID primitive.ObjectID) models.Order {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var order models.Order
+	if err := OrderCollection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		t.Fatal("Error fetching order:", err)
+	}
+	return order
+}
+
+func TestCheckoutIntentWebhookFlow(t *testing.T) {
+	requireDatabase(t)
+	stub := useStripeStub(t)
+	router := paymentTestRouter()
+
+	_, accessToken := createTestUser(t, "flow@example.com")
+	product := createTestProduct(t, "Brass Sextant", 120, 5)
+
+	order := checkoutTestOrder(t, router, accessToken, product, 2)
+	if order.TotalPrice != 240 {
+		t.Fatalf("order total = %v, want 240 from the catalog price", order.TotalPrice)
+	}
+	if order.Status != models.OrderStatusPendingPayment {
+		t.Fatalf("order status = %q, want %q", order.Status, models.OrderStatusPendingPayment)
+	}
+
+	var intent struct {
+		PaymentIntentID string `json:"payment_intent_id"`
+		ClientSecret    string `json:"client_secret"`
+	}
+	w := serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, &intent)
+	if intent.ClientSecret == "" {
+		t.Error("no client secret returned")
+	}
+	if amount := stub.intentField(intent.PaymentIntentID, "amount"); amount != int64(24000) {
+		t.Errorf("PaymentIntent amount = %v, want 24000", amount)
+	}
+
+	// Asking again reuses the open PaymentIntent
+	var again struct {
+		PaymentIntentID string `json:"payment_intent_id"`
+	}
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusOK, &again)
+	if again.PaymentIntentID != intent.PaymentIntentID {
+		t.Errorf("second intent = %s, want the first one %s", again.PaymentIntentID, intent.PaymentIntentID)
+	}
+
+	payload, signature := signedWebhookEvent(t, "evt_flow_1", "payment_intent.succeeded", map[string]interface{}{
+		"id": intent.PaymentIntentID, "object": "payment_intent", "status": "succeeded",
+	})
+	decodeResponse(t, postWebhook(router, payload, signature), http.StatusOK, nil)
+
+	paid := findTestOrder(t, order.OrderID)
+	if paid.Status != models.OrderStatusPaid || paid.PaymentStatus != models.PaymentStatusSucceeded {
+		t.Fatalf("order is %q with payment %q, want %q with %q", paid.Status, paid.PaymentStatus, models.OrderStatusPaid, models.PaymentStatusSucceeded)
+	}
+
+	// A redelivered event is acknowledged without being applied again
+	var duplicate struct {
+		Message string `json:"message"`
+	}
+	decodeResponse(t, postWebhook(router, payload, signature), http.StatusOK, &duplicate)
+	if duplicate.Message != "Event already processed" {
+		t.Errorf("redelivered event answered %q", duplicate.Message)
+	}
+	if history := findTestOrder(t, order.OrderID).StatusHistory; len(history) != len(paid.StatusHistory) {
+		t.Errorf("redelivered event changed the status history from %d to %d entries", len(paid.StatusHistory), len(history))
+	}
+
+	// Paid orders take no further payments
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusBadRequest, nil)
+}
+
+func TestConfirmPaymentDeclined(t *testing.T) {
+	requireDatabase(t)
+	useStripeStub(t)
+	router := paymentTestRouter()
+
+	_, accessToken := createTestUser(t, "declined@example.com")
+	product := createTestProduct(t, "Pocket Chronometer", 80, 3)
+	order := checkoutTestOrder(t, router, accessToken, product, 1)
+
+	w := serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, nil)
+
+	var declined struct {
+		PaymentStatus string `json:"payment_status"`
+	}
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/confirm", gin.H{"payment_method": "pm_card_chargeDeclined"}, accessToken)
+	decodeResponse(t, w, http.StatusPaymentRequired, &declined)
+	if declined.PaymentStatus != models.PaymentStatusFailed {
+		t.Errorf("payment_status = %q, want %q", declined.PaymentStatus, models.PaymentStatusFailed)
+	}
+
+	// A failed attempt can be retried with another card
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/confirm", gin.H{"payment_method": "pm_card_visa"}, accessToken)
+	decodeResponse(t, w, http.StatusOK, nil)
+	if got := findTestOrder(t, order.OrderID); got.Status != models.OrderStatusPaid {
+		t.Errorf("order status = %q, want %q", got.Status, models.OrderStatusPaid)
+	}
+}
+
+func TestCancelledOrderCannotBePaid(t *testing.T) {
+	requireDatabase(t)
+	stub := useStripeStub(t)
+	router := paymentTestRouter()
+
+	_, accessToken := createTestUser(t, "cancelled@example.com")
+	product := createTestProduct(t, "Orrery", 300, 2)
+	order := checkoutTestOrder(t, router, accessToken, product, 1)
+
+	var intent struct {
+		PaymentIntentID string `json:"payment_intent_id"`
+	}
+	w := serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, &intent)
+
+	w = serve(t, router, http.MethodPost, "/orders/"+order.OrderID.Hex()+"/cancel", nil, accessToken)
+	decodeResponse(t, w, http.StatusOK, nil)
+	if status := stub.intentField(intent.PaymentIntentID, "status"); status != "canceled" {
+		t.Errorf("PaymentIntent status after cancelling = %v, want canceled", status)
+	}
+
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusConflict, nil)
+	w = serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/confirm", gin.H{"payment_method": "pm_card_visa"}, accessToken)
+	decodeResponse(t, w, http.StatusConflict, nil)
+	if refunds := stub.refunded(); len(refunds) != 0 {
+		t.Errorf("unpaid order was refunded: %v", refunds)
+	}
+}
+
+func TestPaymentAfterCancelIsRefunded(t *testing.T) {
+	requireDatabase(t)
+	stub := useStripeStub(t)
+	router := paymentTestRouter()
+
+	_, accessToken := createTestUser(t, "latepayment@example.com")
+	product := createTestProduct(t, "Astrolabe", 150, 2)
+	order := checkoutTestOrder(t, router, accessToken, product, 1)
+
+	var intent struct {
+		PaymentIntentID string `json:"payment_intent_id"`
+	}
+	w := serve(t, router, http.MethodPost, "/payments/"+order.OrderID.Hex()+"/intent", nil, accessToken)
+	decodeResponse(t, w, http.StatusCreated, &intent)
+
+	// Stripe cannot cancel a processing PaymentIntent, the order is cancelled anyway
+	stub.setIntentStatus(intent.PaymentIntentID, "processing")
+	w = serve(t, router, http.MethodPost, "/orders/"+order.OrderID.Hex()+"/cancel", nil, accessToken)
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	stub.setIntentStatus(intent.PaymentIntentID, "succeeded")
+	payload, signature := signedWebhookEvent(t, "evt_late_1", "payment_intent.succeeded", map[string]interface{}{
+		"id": intent.PaymentIntentID, "object": "payment_intent", "status": "succeeded",
+	})
+	decodeResponse(t, postWebhook(router, payload, signature), http.StatusOK, nil)
+
+	got := findTestOrder(t, order.OrderID)
+	if got.Status != models.OrderStatusCancelled || got.PaymentStatus != models.PaymentStatusRefunded {
+		t.Errorf("order is %q with payment %q, want %q with %q", got.Status, got.PaymentStatus, models.OrderStatusCancelled, models.PaymentStatusRefunded)
+	}
+	if refunds := stub.refunded(); len(refunds) != 1 || refunds[0] != intent.PaymentIntentID {
+		t.Errorf("refunds = %v, want one of %s", refunds, intent.PaymentIntentID)
+	}
+}
+
+func TestStripeWebhookWithoutSecret(t *testing.T) {
+	previous := StripeWebhookSecret
+	StripeWebhookSecret = ""
+	defer func() { StripeWebhookSecret = previous }()
+
+	router := gin.New()
+	router.POST("/payments/webhook", StripeWebhook())
+
+	payload, signature := signedWebhookEvent(t, "evt_unconfigured", "payment_intent.succeeded", map[string]string{"id": "pi_forged"})
+	if w := postWebhook(router, payload, signature); w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestStripeWebhookRejectsBadSignature(t *testing.T) {
+	previous := StripeWebhookSecret
+	StripeWebhookSecret = testWebhookSecret
+	defer func() { StripeWebhookSecret = previous }()
+
+	router := gin.New()
+	router.POST("/payments/webhook", StripeWebhook())
+
+	payload, _ := signedWebhookEvent(t, "evt_forged", "payment_intent.succeeded", map[string]string{"id": "pi_forged"})
+	forged := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: "whsec_other"})
+
+	for name, signature := range map[string]string{
+		"missing":      "",
+		"wrong secret": forged.Header,
+		"garbage":      "t=1,v1=deadbeef",
+	} {
+		if w := postWebhook(router, payload, signature); w.Code != http.StatusBadRequest {
+			t.Errorf("%s signature: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"aevum-emporium-be/internal/datasource"
 	"aevum-emporium-be/internal/models"
 	"context"
-	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,53 +17,8 @@ import (
 
 var ProductCollection *mongo.Collection = datasource.ProductData(datasource.Client)
 
-func isAdmin(userID string) (bool, error) {
-	// Convert userID string to ObjectID
-	objID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return false, fmt.Errorf("invalid user ID format")
-	}
-
-	fmt.Println("Checking user role for UID:", userID)
-
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	defer cancel()
-
-	var user models.User
-	// Query based on ObjectID
-	err = UserCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return false, nil // No user found, not an admin
-		}
-		return false, err // Other errors (DB issues)
-	}
-
-	fmt.Println("User role found:", user.Role)
-
-	// Check if the user role is admin
-	return user.Role == "admin", nil
-}
-
 func AddProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Ensure user is authenticated and has admin role
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		isAdmin, err := isAdmin(userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user role"})
-			return
-		}
-		if !isAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to add products"})
-			return
-		}
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
@@ -78,7 +33,19 @@ func AddProduct() gin.HandlerFunc {
 		product.CreatedAt = time.Now()
 		product.UpdatedAt = time.Now()
 
-		_, err = ProductCollection.InsertOne(ctx, product)
+		product.NameLower = strings.ToLower(product.Name)
+
+		if err := resolveProductCategory(ctx, &product); err != nil {
+			respondProductCategoryError(c, err)
+			return
+		}
+
+		// Ratings and sales are counted by the server
+		product.Rating = 0
+		product.ReviewCount = 0
+		product.SoldCount = 0
+
+		_, err := ProductCollection.InsertOne(ctx, product)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Product could not be created"})
 			return
@@ -88,13 +55,37 @@ func AddProduct() gin.HandlerFunc {
 	}
 }
 
+// GetProducts lists the catalog a page at a time, see parseProductPage for
+// the query parameters.
 func GetProducts() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var products []models.Product
-		cursor, err := ProductCollection.Find(ctx, bson.M{})
+		filter, err := parseProductFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !applyCategoryFilter(ctx, c, filter) {
+			return
+		}
+
+		page, err := parseProductPage(c, filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		total, err := ProductCollection.CountDocuments(ctx, page.filter)
+		if err != nil {
+			log.Println("Error counting products:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
+			return
+		}
+
+		products := make([]models.Product, 0)
+		cursor, err := ProductCollection.Find(ctx, page.find, page.options)
 		if err != nil {
 			log.Println("Error fetching products:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
@@ -108,13 +99,20 @@ func GetProducts() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, products)
+		response, err := page.envelope(products, total)
+		if err != nil {
+			log.Println("Error encoding product cursor:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
 	}
 }
 
 func GetProductByID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		productID := c.Param("id")
+		productID := c.Param("product_id")
 		objID, err := primitive.ObjectIDFromHex(productID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
@@ -136,26 +134,11 @@ func GetProductByID() gin.HandlerFunc {
 	}
 }
 
+// UpdateProduct replaces a product's details. Stock is left alone, orders
+// reserve it concurrently; it changes through AdjustProductStock.
 func UpdateProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Ensure user is authenticated and has admin role
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		isAdmin, err := isAdmin(userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user role"})
-			return
-		}
-		if !isAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update products"})
-			return
-		}
-
-		productID := c.Param("id")
+		productID := c.Param("product_id")
 		objID, err := primitive.ObjectIDFromHex(productID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
@@ -173,13 +156,19 @@ func UpdateProduct() gin.HandlerFunc {
 
 		product.UpdatedAt = time.Now()
 
+		if err := resolveProductCategory(ctx, &product); err != nil {
+			respondProductCategoryError(c, err)
+			return
+		}
+
 		update := bson.M{"$set": bson.M{
-			"name":           product.Name,
-			"category":       product.Category,
-			"description":    product.Description,
-			"price":          product.Price,
-			"stock_quantity": product.StockQuantity,
-			"updated_at":     product.UpdatedAt,
+			"name":        product.Name,
+			"name_lower":  strings.ToLower(product.Name),
+			"category_id": product.CategoryID,
+			"category":    product.Category,
+			"description": product.Description,
+			"price":       product.Price,
+			"updated_at":  product.UpdatedAt,
 		}}
 
 		_, err = ProductCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
@@ -195,24 +184,7 @@ func UpdateProduct() gin.HandlerFunc {
 
 func DeleteProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Ensure user is authenticated and has admin role
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		isAdmin, err := isAdmin(userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user role"})
-			return
-		}
-		if !isAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to delete products"})
-			return
-		}
-
-		productID := c.Param("id")
+		productID := c.Param("product_id")
 		objID, err := primitive.ObjectIDFromHex(productID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
@@ -232,45 +204,3 @@ func DeleteProduct() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Product successfully deleted"})
 	}
 }
-
-func SearchProductByCategoryOrName() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		category := c.Query("category")
-		name := c.Query("name")
-
-		var filter bson.M
-		if category != "" && name != "" {
-			filter = bson.M{"$and": []bson.M{
-				{"category": bson.M{"$regex": category, "$options": "i"}},
-				{"name": bson.M{"$regex": name, "$options": "i"}},
-			}}
-		} else if category != "" {
-			filter = bson.M{"category": bson.M{"$regex": category, "$options": "i"}}
-		} else if name != "" {
-			filter = bson.M{"name": bson.M{"$regex": name, "$options": "i"}}
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide either category or name to search"})
-			return
-		}
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var products []models.Product
-		cursor, err := ProductCollection.Find(ctx, filter)
-		if err != nil {
-			log.Println("Error fetching products by category or name:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
-			return
-		}
-		defer cursor.Close(ctx)
-
-		if err := cursor.All(ctx, &products); err != nil {
-			log.Println("Error decoding products:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding products"})
-			return
-		}
-
-		c.JSON(http.StatusOK, products)
-	}
-}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// priceFacetBoundaries are the lower ends of the price buckets. The last
+// bucket has no upper end.
+var priceFacetBoundaries = []float64{0, 25, 50, 100, 250, 500, 1000, 2500}
+
+// ratingFacetThresholds are the "N stars & up" rating buckets.
+var ratingFacetThresholds = []int{4, 3, 2, 1}
+
+type categoryFacet struct {
+	CategoryID *primitive.ObjectID `bson:"_id" json:"category_id"` // null for uncategorised products
+	Value      string              `bson:"name" json:"value"`
+	Count      int64               `bson:"count" json:"count"`
+}
+
+type priceFacet struct {
+	Min   float64  `bson:"_id" json:"min"`
+	Max   *float64 `bson:"-" json:"max"` // null for the last bucket
+	Count int64    `bson:"count" json:"count"`
+}
+
+type ratingFacet struct {
+	MinRating int   `json:"min_rating"`
+	Count     int64 `json:"count"`
+}
+
+type availabilityFacet struct {
+	InStock    int64 `json:"in_stock"`
+	OutOfStock int64 `json:"out_of_stock"`
+}
+
+// productFacets are the counts shown next to the search results for
+// narrowing them down.
+type productFacets struct {
+	Category     []categoryFacet   `json:"category"`
+	Price        []priceFacet      `json:"price"`
+	Rating       []ratingFacet     `json:"rating"`
+	Availability availabilityFacet `json:"availability"`
+}
+
+func emptyProductFacets() productFacets {
+	return productFacets{
+		Category: make([]categoryFacet, 0),
+		Price:    make([]priceFacet, 0),
+		Rating:   make([]ratingFacet, 0),
+	}
+}
+
+// withoutCondition returns a copy of filter without key.
+func withoutCondition(filter bson.M, key string) bson.M {
+	remaining := make(bson.M, len(filter))
+	for k, v := range filter {
+		if k != key {
+			remaining[k] = v
+		}
+	}
+	return remaining
+}
+
+// countProductFacets counts the products matching condition, the search
+// query, by category, price, rating and availability in a single $facet
+// aggregation. Each facet applies every listing filter except its own, so
+// picking a category still shows how many results the other categories have.
+func countProductFacets(ctx context.Context, condition bson.M, filter bson.M) (productFacets, error) {
+	facets := emptyProductFacets()
+
+	ratingCounts := bson.M{"_id": nil}
+	for _, threshold := range ratingFacetThresholds {
+		ratingCounts[ratingFacetKey(threshold)] = bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$rating", threshold}}, 1, 0}}}
+	}
+
+	cursor, err := ProductCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: condition}},
+		{{Key: "$facet", Value: bson.M{
+			"category": bson.A{
+				bson.M{"$match": withoutCondition(filter, "category_id")},
+				bson.M{"$group": bson.M{"_id": "$category_id", "name": bson.M{"$first": "$category"}, "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "name", Value: 1}}},
+			},
+			"price": bson.A{
+				bson.M{"$match": withoutCondition(filter, "price")},
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price",
+					"boundaries": priceFacetBoundaries,
+					// Prices above the last boundary join the last bucket
+					"default": priceFacetBoundaries[len(priceFacetBoundaries)-1],
+					"output":  bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+			"rating": bson.A{
+				bson.M{"$match": withoutCondition(filter, "rating")},
+				bson.M{"$group": ratingCounts},
+				bson.M{"$project": bson.M{"_id": 0}},
+			},
+			"availability": bson.A{
+				bson.M{"$match": withoutCondition(filter, "stock_quantity")},
+				bson.M{"$group": bson.M{
+					"_id":          nil,
+					"in_stock":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$stock_quantity", 0}}, 1, 0}}},
+					"out_of_stock": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$stock_quantity", 0}}, 0, 1}}},
+				}},
+			},
+		}}},
+	})
+	if err != nil {
+		return facets, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Category     []categoryFacet    `bson:"category"`
+		Price        []priceFacet       `bson:"price"`
+		Rating       []map[string]int64 `bson:"rating"`
+		Availability []struct {
+			InStock    int64 `bson:"in_stock"`
+			OutOfStock int64 `bson:"out_of_stock"`
+		} `bson:"availability"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return facets, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return facets, err
+	}
+
+	if result.Category != nil {
+		facets.Category = result.Category
+	}
+	for _, bucket := range result.Price {
+		for i, boundary := range priceFacetBoundaries[:len(priceFacetBoundaries)-1] {
+			if bucket.Min == boundary {
+				upper := priceFacetBoundaries[i+1]
+				bucket.Max = &upper
+			}
+		}
+		facets.Price = append(facets.Price, bucket)
+	}
+	if len(result.Rating) > 0 {
+		for _, threshold := range ratingFacetThresholds {
+			facets.Rating = append(facets.Rating, ratingFacet{MinRating: threshold, Count: result.Rating[0][ratingFacetKey(threshold)]})
+		}
+	}
+	if len(result.Availability) > 0 {
+		facets.Availability = availabilityFacet(result.Availability[0])
+	}
+	return facets, nil
+}
+
+func ratingFacetKey(threshold int) string {
+	return "gte_" + strconv.Itoa(threshold)
+}
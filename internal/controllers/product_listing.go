@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultProductPageSize = 20
+	maxProductPageSize     = 100
+)
+
+// productSort is a sort order of the product listing.
+type productSort struct {
+	field string
+	order int // the default direction, 1 ascending or -1 descending
+}
+
+// productSorts are the values accepted by ?sort=.
+var productSorts = map[string]productSort{
+	"price":      {field: "price", order: 1},
+	"created_at": {field: "created_at", order: -1},
+	"rating":     {field: "rating", order: -1},
+	"popularity": {field: "sold_count", order: -1},
+}
+
+// value returns the sort key of product.
+func (s productSort) value(product models.Product) interface{} {
+	switch s.field {
+	case "price":
+		return product.Price
+	case "rating":
+		return product.Rating
+	case "sold_count":
+		return product.SoldCount
+	default:
+		return product.CreatedAt
+	}
+}
+
+// productCursor is the position after the last product of a page: its sort
+// key and ID, so products with equal keys are neither skipped nor repeated.
+type productCursor struct {
+	Sort  string          `json:"s"`
+	Order int             `json:"o"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+func encodeProductCursor(sortName string, sort productSort, order int, product models.Product) (string, error) {
+	value, err := json.Marshal(sort.value(product))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(productCursor{Sort: sortName, Order: order, Value: value, ID: product.ProductID.Hex()})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+var errInvalidCursor = errors.New("Invalid cursor")
+
+// decodeProductCursor returns the filter matching the products after the
+// cursor. The cursor must come from a listing with the same sort order.
+func decodeProductCursor(encoded string, sortName string, sort productSort, order int) (bson.M, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	if cursor.Sort != sortName || cursor.Order != order {
+		return nil, errors.New("The cursor belongs to a different sort order")
+	}
+	id, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	// Decode the key into the type it is stored as, so it compares correctly
+	var value interface{}
+	switch sort.field {
+	case "created_at":
+		var t time.Time
+		err = json.Unmarshal(cursor.Value, &t)
+		value = t
+	case "sold_count":
+		var n int
+		err = json.Unmarshal(cursor.Value, &n)
+		value = n
+	default:
+		var f float64
+		err = json.Unmarshal(cursor.Value, &f)
+		value = f
+	}
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	after := "$gt"
+	if order < 0 {
+		after = "$lt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{sort.field: bson.M{after: value}},
+		bson.M{sort.field: value, "_id": bson.M{after: id}},
+	}}, nil
+}
+
+// parseProductFilter builds the product filter from the query parameters
+// min_price, max_price, min_rating, in_stock and discounted. ?category= needs
+// the database and is added by applyCategoryFilter.
+func parseProductFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	price := bson.M{}
+	for param, operator := range map[string]string{"min_price": "$gte", "max_price": "$lte"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", param)
+		}
+		price[operator] = amount
+	}
+	if minPrice, ok := price["$gte"].(float64); ok {
+		if maxPrice, ok := price["$lte"].(float64); ok && minPrice > maxPrice {
+			return nil, errors.New("min_price cannot be greater than max_price")
+		}
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+
+	if value := c.Query("min_rating"); value != "" {
+		rating, err := strconv.ParseFloat(value, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return nil, errors.New("min_rating must be a number from 0 to 5")
+		}
+		filter["rating"] = bson.M{"$gte": rating}
+	}
+
+	for param, condition := range map[string]bson.M{
+		"in_stock":   {"stock_quantity": bson.M{"$gt": 0}},
+		"discounted": {"discount": bson.M{"$gt": 0}},
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		only, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", param)
+		}
+		if only {
+			for key, cond := range condition {
+				filter[key] = cond
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// productPage is one page of the product listing.
+type productPage struct {
+	filter   bson.M // matches every product of the listing, for the total
+	find     bson.M // the filter with the cursor position applied
+	options  *options.FindOptions
+	limit    int
+	page     int // 0 when paginating by cursor
+	sortName string
+	sort     productSort
+	order    int
+}
+
+// parseProductPage reads ?sort= and ?order=, and either ?cursor= or ?page=
+// together with ?limit= of a listing request for the products matching filter.
+func parseProductPage(c *gin.Context, filter bson.M) (productPage, error) {
+	var p productPage
+	var err error
+
+	p.filter = filter
+	p.find = filter
+
+	p.sortName = c.DefaultQuery("sort", "created_at")
+	sort, ok := productSorts[p.sortName]
+	if !ok {
+		return p, errors.New("sort must be one of price, created_at, rating, popularity")
+	}
+	p.sort = sort
+	switch c.Query("order") {
+	case "":
+		p.order = sort.order
+	case "asc":
+		p.order = 1
+	case "desc":
+		p.order = -1
+	default:
+		return p, errors.New("order must be asc or desc")
+	}
+
+	if p.limit, err = parseLimit(c); err != nil {
+		return p, err
+	}
+
+	// Fetch one more than asked for to know whether there is a next page
+	p.options = options.Find().
+		SetSort(bson.D{{Key: sort.field, Value: p.order}, {Key: "_id", Value: p.order}}).
+		SetLimit(int64(p.limit) + 1)
+
+	cursor, page := c.Query("cursor"), c.Query("page")
+	switch {
+	case cursor != "" && page != "":
+		return p, errors.New("Use either cursor or page, not both")
+	case cursor != "":
+		after, err := decodeProductCursor(cursor, p.sortName, sort, p.order)
+		if err != nil {
+			return p, err
+		}
+		p.find = bson.M{"$and": bson.A{filter, after}}
+	default:
+		if p.page, err = parsePage(c); err != nil {
+			return p, err
+		}
+		p.options.SetSkip(int64((p.page - 1) * p.limit))
+	}
+
+	return p, nil
+}
+
+// parseLimit reads the page size from ?limit=.
+func parseLimit(c *gin.Context) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return defaultProductPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxProductPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxProductPageSize)
+	}
+	return limit, nil
+}
+
+// parsePage reads the 1-based page number from ?page=.
+func parsePage(c *gin.Context) (int, error) {
+	value := c.Query("page")
+	if value == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(value)
+	if err != nil || page < 1 {
+		return 0, errors.New("page must be a positive number")
+	}
+	return page, nil
+}
+
+// envelope trims the products fetched for the page to its size and wraps them
+// with the total and the cursor of the next page, which is null on the last.
+func (p productPage) envelope(products []models.Product, total int64) (gin.H, error) {
+	var nextCursor interface{}
+	if len(products) > p.limit {
+		products = products[:p.limit]
+		cursor, err := encodeProductCursor(p.sortName, p.sort, p.order, products[len(products)-1])
+		if err != nil {
+			return nil, err
+		}
+		nextCursor = cursor
+	}
+
+	response := gin.H{
+		"products":    products,
+		"total":       total,
+		"limit":       p.limit,
+		"next_cursor": nextCursor,
+	}
+	if p.page > 0 {
+		response["page"] = p.page
+	}
+	return response, nil
+}
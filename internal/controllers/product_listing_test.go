@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testCursorProduct() models.Product {
+	return models.Product{
+		ProductID: primitive.NewObjectID(),
+		Name:      "Brass Sextant",
+		Price:     129.5,
+		Rating:    4.25,
+		SoldCount: 17,
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+	}
+}
+
+// rawCursor encodes a cursor the way encodeProductCursor does, but with any
+// content, like a client tampering with one would.
+func rawCursor(t *testing.T, cursor interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestProductCursorRoundTrip(t *testing.T) {
+	product := testCursorProduct()
+
+	tests := []struct {
+		sortName string
+		order    int
+		operator string
+		value    interface{}
+	}{
+		{"price", 1, "$gt", 129.5},
+		{"price", -1, "$lt", 129.5},
+		{"created_at", -1, "$lt", product.CreatedAt},
+		{"created_at", 1, "$gt", product.CreatedAt},
+		{"rating", -1, "$lt", 4.25},
+		{"popularity", -1, "$lt", 17},
+	}
+	for _, tt := range tests {
+		sort := productSorts[tt.sortName]
+		encoded, err := encodeProductCursor(tt.sortName, sort, tt.order, product)
+		if err != nil {
+			t.Fatalf("encodeProductCursor(%s) returned error: %v", tt.sortName, err)
+		}
+
+		filter, err := decodeProductCursor(encoded, tt.sortName, sort, tt.order)
+		if err != nil {
+			t.Fatalf("decodeProductCursor(%s, %d) returned error: %v", tt.sortName, tt.order, err)
+		}
+		want := bson.M{"$or": bson.A{
+			bson.M{sort.field: bson.M{tt.operator: tt.value}},
+			bson.M{sort.field: tt.value, "_id": bson.M{tt.operator: product.ProductID}},
+		}}
+		if !reflect.DeepEqual(filter, want) {
+			t.Errorf("cursor of %s order %d decoded to %v, want %v", tt.sortName, tt.order, filter, want)
+		}
+	}
+}
+
+func TestDecodeProductCursorRejects(t *testing.T) {
+	product := testCursorProduct()
+	sort := productSorts["price"]
+	valid, err := encodeProductCursor("price", sort, 1, product)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing one character breaks the JSON inside
+	tampered := []byte(valid)
+	tampered[0] ^= 'A' ^ 'B'
+
+	tests := []struct {
+		name    string
+		encoded string
+		invalid bool // the generic "Invalid cursor" rather than a sort mismatch
+	}{
+		{"empty", "", true},
+		{"not base64", "!!not-base64!!", true},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"price"}`)) + "=", true},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("garbage")), true},
+		{"tampered", string(tampered), true},
+		{"JSON array", rawCursor(t, []string{"price"}), true},
+		{"bad ID", rawCursor(t, gin.H{"s": "price", "o": 1, "v": 10, "id": "not-an-id"}), true},
+		{"missing ID", rawCursor(t, gin.H{"s": "price", "o": 1, "v": 10}), true},
+		{"value of the wrong type", rawCursor(t, gin.H{"s": "price", "o": 1, "v": "ten", "id": product.ProductID.Hex()}), true},
+		{"missing value", rawCursor(t, gin.H{"s": "price", "o": 1, "id": product.ProductID.Hex()}), true},
+		{"other sort", rawCursor(t, gin.H{"s": "rating", "o": 1, "v": 10, "id": product.ProductID.Hex()}), false},
+		{"other order", rawCursor(t, gin.H{"s": "price", "o": -1, "v": 10, "id": product.ProductID.Hex()}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := decodeProductCursor(tt.encoded, "price", sort, 1)
+			if err == nil {
+				t.Fatalf("decodeProductCursor accepted it: %v", filter)
+			}
+			if (err == errInvalidCursor) != tt.invalid {
+				t.Errorf("decodeProductCursor error = %q", err)
+			}
+		})
+	}
+}
+
+func TestDecodeProductCursorTimeValue(t *testing.T) {
+	sort := productSorts["created_at"]
+	for name, value := range map[string]interface{}{
+		"number":     12,
+		"bad string": "yesterday",
+	} {
+		encoded := rawCursor(t, gin.H{"s": "created_at", "o": -1, "v": value, "id": primitive.NewObjectID().Hex()})
+		if _, err := decodeProductCursor(encoded, "created_at", sort, -1); err != errInvalidCursor {
+			t.Errorf("%s: error = %v, want errInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestDecodeProductCursorCountValue(t *testing.T) {
+	// sold_count is an integer, a fraction cannot come from a real cursor
+	encoded := rawCursor(t, gin.H{"s": "popularity", "o": -1, "v": 1.5, "id": primitive.NewObjectID().Hex()})
+	if _, err := decodeProductCursor(encoded, "popularity", productSorts["popularity"], -1); err != errInvalidCursor {
+		t.Errorf("error = %v, want errInvalidCursor", err)
+	}
+}
+
+func listingContext(target string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	return c
+}
+
+func TestParseProductPage(t *testing.T) {
+	product := testCursorProduct()
+	priceCursor, err := encodeProductCursor("price", productSorts["price"], 1, product)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+		page    int
+		limit   int
+		cursor  bool
+	}{
+		{"defaults", "/products", false, 1, defaultProductPageSize, false},
+		{"page and limit", "/products?page=3&limit=5", false, 3, 5, false},
+		{"cursor", "/products?sort=price&cursor=" + priceCursor, false, 0, defaultProductPageSize, true},
+		{"cursor of another sort", "/products?sort=rating&cursor=" + priceCursor, true, 0, 0, false},
+		{"cursor of another order", "/products?sort=price&order=desc&cursor=" + priceCursor, true, 0, 0, false},
+		{"garbage cursor", "/products?cursor=garbage", true, 0, 0, false},
+		{"cursor and page", "/products?sort=price&page=2&cursor=" + priceCursor, true, 0, 0, false},
+		{"unknown sort", "/products?sort=name", true, 0, 0, false},
+		{"unknown order", "/products?order=up", true, 0, 0, false},
+		{"limit too large", "/products?limit=101", true, 0, 0, false},
+		{"limit zero", "/products?limit=0", true, 0, 0, false},
+		{"page zero", "/products?page=0", true, 0, 0, false},
+		{"page not a number", "/products?page=two", true, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := bson.M{"price": bson.M{"$gte": 10.0}}
+			p, err := parseProductPage(listingContext(tt.target), filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseProductPage accepted it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("parseProductPage returned error:", err)
+			}
+			if p.page != tt.page || p.limit != tt.limit {
+				t.Errorf("page, limit = %d, %d, want %d, %d", p.page, p.limit, tt.page, tt.limit)
+			}
+			if !reflect.DeepEqual(p.filter, filter) {
+				t.Errorf("the total counts %v, want the unpaginated filter %v", p.filter, filter)
+			}
+			if _, paginated := p.find["$and"]; paginated != tt.cursor {
+				t.Errorf("find = %v, cursor applied: %v, want %v", p.find, paginated, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestProductPageEnvelope(t *testing.T) {
+	p, err := parseProductPage(listingContext("/products?sort=price&limit=2"), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	products := []models.Product{testCursorProduct(), testCursorProduct(), testCursorProduct()}
+
+	// One product more than the limit means there is a next page
+	response, err := p.envelope(products, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response["products"].([]models.Product); len(got) != 2 {
+		t.Errorf("page holds %d products, want 2", len(got))
+	}
+	next, ok := response["next_cursor"].(string)
+	if !ok {
+		t.Fatalf("next_cursor = %v, want a cursor", response["next_cursor"])
+	}
+	filter, err := decodeProductCursor(next, "price", productSorts["price"], 1)
+	if err != nil {
+		t.Fatal("next_cursor does not decode:", err)
+	}
+	lastID := filter["$or"].(bson.A)[1].(bson.M)["_id"].(bson.M)["$gt"]
+	if lastID != products[1].ProductID {
+		t.Errorf("next_cursor points after %v, want the last product of the page %v", lastID, products[1].ProductID)
+	}
+
+	// The last page has no next cursor
+	response, err = p.envelope(products[:2], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response["next_cursor"] != nil {
+		t.Errorf("last page has next_cursor %v", response["next_cursor"])
+	}
+	if response["page"] != 1 {
+		t.Errorf("page = %v, want 1", response["page"])
+	}
+}
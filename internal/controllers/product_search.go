@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	"aevum-emporium-be/internal/search"
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxSearchQueryLength = 200
+	// descriptionSnippetLength is the length of the description excerpt
+	// shown with a search result
+	descriptionSnippetLength = 160
+)
+
+// How a search matched, reported as "match" in the response.
+const (
+	matchText    = "text"    // the text index matched the query
+	matchLiteral = "literal" // the query occurs as typed in a name, description or category
+	matchFuzzy   = "fuzzy"   // the text index matched after correcting typos
+	matchNone    = "none"
+)
+
+// productSearchResult is a product found by a search, with its relevance
+// and the matched text highlighted.
+type productSearchResult struct {
+	models.Product `bson:",inline"`
+	Score          float64           `bson:"score,omitempty" json:"score"`
+	Highlights     map[string]string `bson:"-" json:"highlights,omitempty"`
+}
+
+// productSearch is the outcome of searchProducts.
+type productSearch struct {
+	Results        []productSearchResult
+	Total          int64
+	Match          string
+	CorrectedQuery string
+	// condition is the part of the filter that matched the query, without
+	// the listing filters
+	condition bson.M
+	terms     []string
+}
+
+// withCondition returns a copy of filter with key set to value.
+func withCondition(filter bson.M, key string, value interface{}) bson.M {
+	combined := make(bson.M, len(filter)+1)
+	for k, v := range filter {
+		combined[k] = v
+	}
+	combined[key] = value
+	return combined
+}
+
+// searchProducts looks for query among the products matching filter. It tries
+// the text index first, which ranks by relevance and understands word forms
+// ("laptops" finds "laptop"). If that finds nothing it looks for the query as
+// typed, which also finds parts of words, and finally retries the text index
+// with misspelled words corrected against the catalog's vocabulary.
+func searchProducts(ctx context.Context, filter bson.M, query string, limit int, page int) (productSearch, error) {
+	result := productSearch{Results: make([]productSearchResult, 0), Match: matchNone}
+	skip := int64((page - 1) * limit)
+
+	textSearch := func(query string) (bool, error) {
+		condition := bson.M{"$text": bson.M{"$search": query}}
+		textFilter := withCondition(filter, "$text", condition["$text"])
+		total, err := ProductCollection.CountDocuments(ctx, textFilter)
+		if err != nil || total == 0 {
+			return false, err
+		}
+		opts := options.Find().
+			SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+			SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}).
+			SetSkip(skip).
+			SetLimit(int64(limit))
+		if err := findSearchResults(ctx, textFilter, opts, &result); err != nil {
+			return false, err
+		}
+		result.Total = total
+		result.condition = condition
+		result.terms = search.Terms(query)
+		return true, nil
+	}
+
+	found, err := textSearch(query)
+	if err != nil {
+		return result, err
+	}
+	if found {
+		result.Match = matchText
+		return result, nil
+	}
+
+	// Escaped, so the query is matched literally and cannot be a regex
+	literal := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	condition := bson.M{"$or": bson.A{
+		bson.M{"name": literal},
+		bson.M{"description": literal},
+		bson.M{"category": literal},
+	}}
+	literalFilter := withCondition(filter, "$or", condition["$or"])
+	total, err := ProductCollection.CountDocuments(ctx, literalFilter)
+	if err != nil {
+		return result, err
+	}
+	if total > 0 {
+		opts := options.Find().
+			SetSort(bson.D{{Key: "sold_count", Value: -1}, {Key: "_id", Value: 1}}).
+			SetSkip(skip).
+			SetLimit(int64(limit))
+		if err := findSearchResults(ctx, literalFilter, opts, &result); err != nil {
+			return result, err
+		}
+		result.Total = total
+		result.condition = condition
+		result.Match = matchLiteral
+		result.terms = []string{query}
+		return result, nil
+	}
+
+	corrected, ok, err := correctQuery(ctx, query)
+	if err != nil || !ok {
+		return result, err
+	}
+	found, err = textSearch(corrected)
+	if err != nil || !found {
+		return result, err
+	}
+	result.Match = matchFuzzy
+	result.CorrectedQuery = corrected
+	return result, nil
+}
+
+func findSearchResults(ctx context.Context, filter bson.M, opts *options.FindOptions, result *productSearch) error {
+	cursor, err := ProductCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, &result.Results)
+}
+
+// correctQuery replaces the misspelled words of query with the closest words
+// of the cached catalog vocabulary. It reports whether anything was corrected.
+func correctQuery(ctx context.Context, query string) (string, bool, error) {
+	vocabulary, err := productVocabulary(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	terms, changed := search.Correct(search.Terms(query), vocabulary)
+	if !changed {
+		return "", false, nil
+	}
+	return strings.Join(terms, " "), true, nil
+}
+
+// highlight fills in the highlighted name, description snippet and category
+// of every result.
+func (s *productSearch) highlight() {
+	for i := range s.Results {
+		result := &s.Results[i]
+		highlights := make(map[string]string)
+		if name := search.Highlight(result.Name, s.terms, 0); name != "" {
+			highlights["name"] = name
+		}
+		if description := search.Highlight(result.Description, s.terms, descriptionSnippetLength); description != "" {
+			highlights["description"] = description
+		}
+		if category := search.Highlight(result.Category, s.terms, 0); category != "" {
+			highlights["category"] = category
+		}
+		if len(highlights) > 0 {
+			result.Highlights = highlights
+		}
+	}
+}
+
+// SearchProducts searches the catalog for ?q=, ranked by relevance. The
+// listing filters (category with its subcategories, price range, min_rating,
+// in_stock, discounted) and ?limit= and ?page= apply. Matched words are wrapped in <em> in each
+// result's highlights, the rest of the highlighted text is HTML-escaped.
+// With ?facets=true the response also counts the results by category, price,
+// rating and availability.
+func SearchProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			// ?name= is what this endpoint used to take
+			query = strings.TrimSpace(c.Query("name"))
+		}
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a search query in q"})
+			return
+		}
+		if len(query) > maxSearchQueryLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
+			return
+		}
+
+		filter, err := parseProductFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit, err := parseLimit(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		withFacets := false
+		if value := c.Query("facets"); value != "" {
+			if withFacets, err = strconv.ParseBool(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "facets must be true or false"})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !applyCategoryFilter(ctx, c, filter) {
+			return
+		}
+
+		result, err := searchProducts(ctx, filter, query, limit, page)
+		if err != nil {
+			log.Println("Error searching products:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching products"})
+			return
+		}
+		result.highlight()
+
+		response := gin.H{
+			"query":    query,
+			"match":    result.Match,
+			"products": result.Results,
+			"total":    result.Total,
+			"limit":    limit,
+			"page":     page,
+		}
+		if result.CorrectedQuery != "" {
+			response["corrected_query"] = result.CorrectedQuery
+		}
+		if result.Match == matchNone {
+			suggestions, err := didYouMean(ctx, query)
+			if err != nil {
+				// Suggestions are a nicety, the empty result stands on its own
+				log.Println("Error building suggestions:", err)
+			} else {
+				response["did_you_mean"] = suggestions
+			}
+		}
+		if withFacets {
+			facets := emptyProductFacets()
+			if result.Match != matchNone {
+				facets, err = countProductFacets(ctx, result.condition, filter)
+				if err != nil {
+					log.Println("Error counting search facets:", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching products"})
+					return
+				}
+			}
+			response["facets"] = facets
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
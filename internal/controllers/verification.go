@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"aevum-emporium-be/internal/mailer"
+	"aevum-emporium-be/internal/models"
+	generate "aevum-emporium-be/internal/token"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mailer delivers account emails. Replace it with a *mailer.MemoryOutbox to
+// capture messages instead of sending them.
+var Mailer mailer.Mailer = mailer.FromEnv()
+
+// AppBaseURL is the public URL links in emails point to.
+var AppBaseURL = strings.TrimSuffix(getEnvOrDefault("APP_BASE_URL", "http://localhost:8080"), "/")
+
+// emailVerificationRequiredFor holds the actions listed in
+// REQUIRE_EMAIL_VERIFICATION ("login", "checkout") that unverified users may not perform.
+var emailVerificationRequiredFor = parseList(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+
+func parseList(value string) map[string]bool {
+	items := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items[item] = true
+		}
+	}
+	return items
+}
+
+// sendVerificationEmail emails the user a single-use link that verifies their
+// address. Issuing a new link invalidates any previous one.
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	signedToken, tokenID, err := generate.GenerateEmailVerificationToken(user.Email, user.UserID.Hex())
+	if err != nil {
+		return err
+	}
+
+	_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.UserID}, bson.M{"$set": bson.M{
+		"email_verification_id": tokenID,
+		"verification_sent_at":  time.Now(),
+	}})
+	if err != nil {
+		return err
+	}
+
+	link := AppBaseURL + "/auth/user/verify?token=" + url.QueryEscape(signedToken)
+	return Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.\n",
+			user.FirstName, link),
+	})
+}
+
+// verificationEmailInterval is how long an account waits between
+// verification emails requested by email address.
+const verificationEmailInterval = time.Minute
+
+// requireVerifiedEmail rejects the request when REQUIRE_EMAIL_VERIFICATION
+// lists action and the user has not verified their email yet.
+func requireVerifiedEmail(ctx context.Context, c *gin.Context, userID primitive.ObjectID, action string) bool {
+	if !emailVerificationRequiredFor[action] {
+		return true
+	}
+
+	var user models.User
+	err := UserCollection.FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(bson.M{"email_verified": 1})).Decode(&user)
+	if err != nil {
+		log.Println("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return false
+	}
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first"})
+		return false
+	}
+	return true
+}
+
+// VerifyEmail redeems the link sent by sendVerificationEmail
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		claims, err := generate.ValidateEmailVerificationToken(c.Query("token"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		userObjectID, err := primitive.ObjectIDFromHex(claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		// Matching on the stored token ID makes the link single-use, matching
+		// on the email ignores links sent to a previous address
+		result, err := UserCollection.UpdateOne(ctx,
+			bson.M{"_id": userObjectID, "email": claims.Email, "email_verification_id": claims.ID},
+			bson.M{
+				"$set":   bson.M{"email_verified": true, "updated_at": time.Now()},
+				"$unset": bson.M{"email_verification_id": ""},
+			},
+		)
+		if err != nil {
+			log.Println("Error verifying email:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying email"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This verification link has already been used or is no longer valid"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerificationEmail sends the authenticated user a fresh verification link
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userObjectID, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			log.Println("Error fetching user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+			return
+		}
+
+		if user.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+			return
+		}
+
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Println("Error sending verification email:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
+
+// RequestVerificationEmail sends a fresh verification link to an unverified
+// account by email address, for users who cannot log in before verifying. The
+// response is the same whether or not the email belongs to such an account,
+// and each account gets at most one email per verificationEmailInterval.
+func RequestVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Do the work in the background so response times don't reveal
+		// whether the account exists
+		go func(email string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+
+			// Claiming the send slot in the same update keeps concurrent
+			// requests from sending more than one email
+			now := time.Now()
+			var user models.User
+			err := UserCollection.FindOneAndUpdate(ctx,
+				bson.M{
+					"email":          email,
+					"email_verified": false,
+					"disabled":       bson.M{"$ne": true},
+					"$or": bson.A{
+						bson.M{"verification_sent_at": bson.M{"$exists": false}},
+						bson.M{"verification_sent_at": bson.M{"$lte": now.Add(-verificationEmailInterval)}},
+					},
+				},
+				bson.M{"$set": bson.M{"verification_sent_at": now}},
+			).Decode(&user)
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					log.Println("Error fetching user for email verification:", err)
+				}
+				return
+			}
+
+			if err := sendVerificationEmail(ctx, user); err != nil {
+				log.Println("Error sending verification email:", err)
+			}
+		}(req.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an unverified account exists for this email, a verification link has been sent"})
+	}
+}
@@ -12,38 +12,58 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// ConnectDB establishes a connection to MongoDB and returns the client.
-func ConnectDB() *mongo.Client {
+// newClient creates the MongoDB client for MONGO_URI. mongo.Connect does not
+// dial, so this works without a reachable database; ConnectDB checks it.
+func newClient() *mongo.Client {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not loaded. Ensure environment variables are set.")
 	}
 
 	uri := os.Getenv("MONGO_URI")
 	if uri == "" {
-		log.Fatal("Error: MONGO_URI is not set in environment variables or .env file")
+		// Only reached by code that never calls ConnectDB, such as unit tests
+		uri = "mongodb://localhost:27017"
 	}
 
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %v", err)
 	}
+	return client
+}
+
+// ConnectDB checks that MongoDB is reachable and returns the client.
+func ConnectDB() *mongo.Client {
+	if os.Getenv("MONGO_URI") == "" {
+		log.Fatal("Error: MONGO_URI is not set in environment variables or .env file")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx, nil); err != nil {
+	if err := Client.Ping(ctx, nil); err != nil {
 		log.Fatalf("Failed to ping MongoDB: %v", err)
 	}
 
 	fmt.Println("Connected to MongoDB")
-	return client
+	return Client
 }
 
-// Global variable to hold the MongoDB client
-var Client *mongo.Client = ConnectDB()
+// Global variable to hold the MongoDB client. Every collection is bound to it
+// when its package is loaded, before main has run.
+var Client *mongo.Client = newClient()
+
+// DatabaseName is the database all collections live in, set by
+// MONGO_DATABASE. Tests point it at a database of their own.
+func DatabaseName() string {
+	if name := os.Getenv("MONGO_DATABASE"); name != "" {
+		return name
+	}
+	return "aevum-emporium"
+}
 
 func getCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	return client.Database("aevum-emporium").Collection(collectionName)
+	return client.Database(DatabaseName()).Collection(collectionName)
 }
 
 func UserData(client *mongo.Client) *mongo.Collection {
@@ -54,6 +74,10 @@ func ProductData(client *mongo.Client) *mongo.Collection {
 	return getCollection(client, "Product")
 }
 
+func CategoryData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "Category")
+}
+
 func OrderData(client *mongo.Client) *mongo.Collection {
 	return getCollection(client, "Order")
 }
@@ -69,3 +93,35 @@ func ReviewData(client *mongo.Client) *mongo.Collection {
 func WishlistData(client *mongo.Client) *mongo.Collection {
 	return getCollection(client, "Wishlist")
 }
+
+func PaymentData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "Payment")
+}
+
+func PaymentEventData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "PaymentEvent")
+}
+
+func SessionData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "Session")
+}
+
+func PasswordResetData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "PasswordReset")
+}
+
+func LoginThrottleData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "LoginThrottle")
+}
+
+func LoginAuditData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "LoginAudit")
+}
+
+func APIKeyData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "APIKey")
+}
+
+func OIDCLoginData(client *mongo.Client) *mongo.Collection {
+	return getCollection(client, "OIDCLogin")
+}
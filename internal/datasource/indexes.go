@@ -0,0 +1,146 @@
+package datasource
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes each collection needs.
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"APIKey": {
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"Cart": {
+		// Abandoned guest carts are removed when their cart token expires
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"Category": {
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}, {Key: "position", Value: 1}}},
+		// Prefix completions of category names
+		{Keys: bson.D{{Key: "name_lower", Value: 1}}},
+	},
+	"LoginThrottle": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"LoginAudit": {
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"OIDCLogin": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"Order": {
+		{
+			Keys: bson.D{{Key: "order_number", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"order_number": bson.M{"$exists": true}}),
+		},
+	},
+	"Product": {
+		// One per sort order of the product listing, _id breaks ties for cursors
+		{Keys: bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "rating", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "sold_count", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "category_id", Value: 1}}},
+		// Prefix completions of product names, best sellers first
+		{Keys: bson.D{{Key: "name_lower", Value: 1}, {Key: "sold_count", Value: -1}}},
+		// Product search; a name match counts most
+		{
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}, {Key: "category", Value: "text"}},
+			Options: options.Index().SetName("product_text").
+				SetWeights(bson.M{"name": 10, "category": 5, "description": 1}),
+		},
+	},
+	"PasswordReset": {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"Session": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		// Expired sessions are removed by MongoDB's TTL monitor
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"User": {
+		// An external account can only be linked to one user
+		{
+			Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+		},
+	},
+}
+
+// backfill brings documents written by an older version up to date.
+type backfill struct {
+	filter bson.M      // matches only the documents still needing the update
+	update interface{} // an update document, or a pipeline to compute from other fields
+}
+
+// collectionBackfills lists the backfills each collection needs.
+var collectionBackfills = map[string][]backfill{
+	"Category": {
+		// Name completions match the lowercased name
+		{
+			filter: bson.M{"name_lower": bson.M{"$exists": false}},
+			update: bson.A{bson.M{"$set": bson.M{"name_lower": bson.M{"$toLower": "$name"}}}},
+		},
+	},
+	"Product": {
+		// The listing sorts and pages by these, cursors never match a missing value
+		{
+			filter: bson.M{"rating": bson.M{"$exists": false}},
+			update: bson.M{"$set": bson.M{"rating": float64(0), "review_count": 0}},
+		},
+		{
+			filter: bson.M{"sold_count": bson.M{"$exists": false}},
+			update: bson.M{"$set": bson.M{"sold_count": 0}},
+		},
+		// Name completions match the lowercased name
+		{
+			filter: bson.M{"name_lower": bson.M{"$exists": false}},
+			update: bson.A{bson.M{"$set": bson.M{"name_lower": bson.M{"$toLower": "$name"}}}},
+		},
+	},
+	"User": {
+		// Raw tokens used to be stored on the user, sessions keep only hashes
+		{
+			filter: bson.M{"$or": bson.A{bson.M{"token": bson.M{"$exists": true}}, bson.M{"refresh_token": bson.M{"$exists": true}}}},
+			update: bson.M{"$unset": bson.M{"token": "", "refresh_token": ""}},
+		},
+		// Social login accounts used to be created with an empty phone number
+		{
+			filter: bson.M{"phone_number": ""},
+			update: bson.M{"$unset": bson.M{"phone_number": ""}},
+		},
+	},
+}
+
+// EnsureIndexes creates any missing indexes and applies the backfills.
+// Creating an index that already exists is a no-op and a backfill only touches
+// documents it has not updated yet, so this is safe to run on every start.
+func EnsureIndexes(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for collectionName, indexes := range collectionIndexes {
+		if _, err := getCollection(client, collectionName).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	for collectionName, backfills := range collectionBackfills {
+		for _, b := range backfills {
+			if _, err := getCollection(client, collectionName).UpdateMany(ctx, b.filter, b.update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
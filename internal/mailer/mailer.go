@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAILER:
+//
+//	smtp   - SMTPMailer configured by SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM
+//	memory - MemoryOutbox, keeps messages in memory
+//	file   - FileOutbox writing to MAIL_OUTBOX_DIR (default "outbox")
+//
+// When MAILER is unset it falls back to the file outbox for development,
+// except in release mode (GIN_MODE=release), where it refuses to start
+// rather than write password reset links to disk.
+func FromEnv() Mailer {
+	kind := os.Getenv("MAILER")
+	if kind == "" {
+		if os.Getenv("GIN_MODE") == "release" {
+			log.Fatal("MAILER is not set; set MAILER=smtp, or MAILER=file to keep emails in MAIL_OUTBOX_DIR on purpose")
+		}
+		log.Println("WARNING: MAILER is not set, emails including password reset links are written to MAIL_OUTBOX_DIR instead of being sent")
+		kind = "file"
+	}
+
+	switch kind {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	case "memory":
+		return &MemoryOutbox{}
+	case "file":
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == "" {
+			dir = "outbox"
+		}
+		return &FileOutbox{Dir: dir}
+	default:
+		log.Fatalf("Unknown MAILER %q, use smtp, file or memory", kind)
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryOutbox keeps sent messages in memory instead of delivering them.
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *MemoryOutbox) Send(ctx context.Context, msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (o *MemoryOutbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// FileOutbox writes each message to its own file in Dir instead of delivering it.
+type FileOutbox struct {
+	Dir string
+}
+
+func (o *FileOutbox) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return os.WriteFile(filepath.Join(o.Dir, name), []byte(content), 0o600)
+}
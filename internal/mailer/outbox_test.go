@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileOutboxWritesOneFilePerMessage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox := &FileOutbox{Dir: dir}
+
+	for _, subject := range []string{"First", "Second"} {
+		msg := Message{To: "ada@example.com", Subject: subject, Body: "Hello\nthere"}
+		if err := outbox.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("outbox has %d files, want 2", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "To: ada@example.com\nSubject: First\n\nHello\nthere\n"
+	if string(data) != want {
+		t.Errorf("message file = %q, want %q", data, want)
+	}
+}
+
+func TestMemoryOutboxReturnsCopy(t *testing.T) {
+	outbox := &MemoryOutbox{}
+	if err := outbox.Send(context.Background(), Message{To: "ada@example.com", Subject: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := outbox.Messages()
+	if len(messages) != 1 || messages[0].Subject != "Hi" {
+		t.Fatalf("Messages() = %+v", messages)
+	}
+	messages[0].Subject = "changed"
+	if got := outbox.Messages()[0].Subject; got != "Hi" {
+		t.Errorf("changing the returned slice changed the outbox: %q", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("GIN_MODE", "")
+
+	t.Run("unset falls back to the file outbox", func(t *testing.T) {
+		t.Setenv("MAILER", "")
+		t.Setenv("MAIL_OUTBOX_DIR", "")
+		outbox, ok := FromEnv().(*FileOutbox)
+		if !ok || outbox.Dir != "outbox" {
+			t.Errorf("FromEnv() = %#v, want a FileOutbox in outbox", outbox)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Setenv("MAILER", "file")
+		t.Setenv("MAIL_OUTBOX_DIR", "/tmp/mail")
+		outbox, ok := FromEnv().(*FileOutbox)
+		if !ok || outbox.Dir != "/tmp/mail" {
+			t.Errorf("FromEnv() = %#v, want a FileOutbox in /tmp/mail", outbox)
+		}
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		t.Setenv("MAILER", "memory")
+		if _, ok := FromEnv().(*MemoryOutbox); !ok {
+			t.Error("FromEnv() is not a MemoryOutbox")
+		}
+	})
+
+	t.Run("smtp", func(t *testing.T) {
+		t.Setenv("MAILER", "smtp")
+		t.Setenv("SMTP_HOST", "mail.example.com")
+		t.Setenv("SMTP_PORT", "")
+		t.Setenv("MAIL_FROM", "shop@example.com")
+		smtp, ok := FromEnv().(*SMTPMailer)
+		if !ok {
+			t.Fatal("FromEnv() is not an SMTPMailer")
+		}
+		if smtp.Host != "mail.example.com" || smtp.Port != "587" || smtp.From != "shop@example.com" {
+			t.Errorf("FromEnv() = %+v", smtp)
+		}
+	})
+}
+
+func TestFromEnvUnknownMailer(t *testing.T) {
+	// log.Fatal exits, so FromEnv runs in a child process
+	if os.Getenv("MAILER_TEST_CHILD") == "1" {
+		FromEnv()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFromEnvUnknownMailer$")
+	cmd.Env = append(os.Environ(), "MAILER_TEST_CHILD=1", "MAILER=sendgrid")
+	output, err := cmd.CombinedOutput()
+	if _, exited := err.(*exec.ExitError); !exited {
+		t.Fatalf("FromEnv did not exit for an unknown MAILER, error: %v, output: %s", err, output)
+	}
+	if !strings.Contains(string(output), `Unknown MAILER "sendgrid"`) {
+		t.Errorf("unexpected output: %s", output)
+	}
+}
@@ -1,14 +1,55 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"aevum-emporium-be/internal/models"
 	token "aevum-emporium-be/internal/token"
 
 	"github.com/gin-gonic/gin"
 )
 
+// mfaRequiredRoles holds the roles listed in MFA_REQUIRED_ROLES (default
+// "admin") whose privileged requests need a token issued after a second factor.
+var mfaRequiredRoles = parseRoles(os.Getenv("MFA_REQUIRED_ROLES"), models.RoleAdmin)
+
+func parseRoles(value string, fallback ...string) map[string]bool {
+	roles := make(map[string]bool)
+	if strings.TrimSpace(value) == "" {
+		for _, role := range fallback {
+			roles[role] = true
+		}
+		return roles
+	}
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles[role] = true
+		}
+	}
+	return roles
+}
+
+// MFARequired reports whether users with role must use two-factor authentication.
+func MFARequired(role string) bool {
+	return mfaRequiredRoles[role]
+}
+
+// requireMFA aborts the request when the role of the token needs a second
+// factor the token was not issued with.
+func requireMFA(c *gin.Context) bool {
+	if MFARequired(c.GetString("role")) && !c.GetBool("mfa") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for this action"})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
 // AuthMiddleware is a Gin middleware that checks for a valid Authorization token.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -33,9 +74,25 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// Validate the token
 		claims, err := token.ValidateToken(ClientToken)
-		if err != "" {
+		if err != nil {
 			// If there's an error validating the token, respond with Unauthorized status
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Reject tokens whose session was logged out or revoked
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		active, sessionErr := token.SessionActive(ctx, claims.Sid)
+		if sessionErr != nil {
+			log.Println("Error checking session:", sessionErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking session"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
 			c.Abort()
 			return
 		}
@@ -43,8 +100,94 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Store the claims in the context
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		c.Set("role", claims.Role)
+		c.Set("mfa", claims.Mfa)
+		c.Set("sid", claims.Sid)
 
 		// Continue to the next handler
 		c.Next()
 	}
 }
+
+// APIKeyOrAuthMiddleware accepts an API key in the X-API-Key header and
+// falls back to AuthMiddleware otherwise. For API keys it sets "api_key_id"
+// and the key's "permissions" instead of the user claims.
+func APIKeyOrAuthMiddleware() gin.HandlerFunc {
+	authMiddleware := AuthMiddleware()
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			authMiddleware(c)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		apiKey, err := token.AuthenticateAPIKey(ctx, key)
+		if err != nil {
+			if err == token.ErrInvalidAPIKey {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				log.Println("Error checking API key:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking API key"})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set("api_key_id", apiKey.KeyID.Hex())
+		c.Set("permissions", apiKey.Permissions)
+
+		c.Next()
+	}
+}
+
+// RequireRole only lets requests through whose token carries one of roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				if !requireMFA(c) {
+					return
+				}
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
+		c.Abort()
+	}
+}
+
+// RequirePermission only lets requests through whose role, or API key,
+// grants permission. Roles that require MFA also need a token issued after a
+// second factor. It must run after AuthMiddleware or APIKeyOrAuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if permissions, ok := c.Get("permissions"); ok {
+			for _, granted := range permissions.([]string) {
+				if granted == permission {
+					c.Next()
+					return
+				}
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
+			c.Abort()
+			return
+		}
+
+		if !models.RoleHasPermission(c.GetString("role"), permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
+			c.Abort()
+			return
+		}
+		if !requireMFA(c) {
+			return
+		}
+
+		c.Next()
+	}
+}
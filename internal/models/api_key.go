@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey lets an integration call the API without a user account. Only the
+// hash of the key is stored; Prefix is kept so admins can tell keys apart.
+type APIKey struct {
+	KeyID       primitive.ObjectID `bson:"_id" json:"key_id"`
+	Name        string             `bson:"name" json:"name"`
+	Prefix      string             `bson:"prefix" json:"prefix"`
+	KeyHash     string             `bson:"key_hash" json:"-"`
+	Permissions []string           `bson:"permissions" json:"permissions"` // Permission constants granted to the key
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // nil for keys that never expire
+	LastUsedAt  *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	Revoked     bool               `bson:"revoked" json:"revoked"`
+	RevokedAt   *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
@@ -6,12 +6,15 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Cart holds the items a user or guest intends to buy. Guest carts have a
+// zero UserID and expire.
 type Cart struct {
 	CartID    primitive.ObjectID `bson:"_id" json:"cart_id"`
 	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
 	Items     []CartItem         `bson:"items" json:"items"`
 	Total     float64            `bson:"total" json:"total"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // only set on guest carts
 }
 
 type CartItem struct {
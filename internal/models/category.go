@@ -0,0 +1,32 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Category groups products. Categories form a tree: Ancestors lists the IDs
+// from the root down to the parent, so the descendants of a category are the
+// categories whose Ancestors contain it.
+type Category struct {
+	CategoryID primitive.ObjectID   `bson:"_id" json:"category_id"`
+	Name       string               `bson:"name" json:"name"`
+	NameLower  string               `bson:"name_lower" json:"-"`        // for prefix search, kept in step with Name
+	Slug       string               `bson:"slug" json:"slug"`           // unique, used in URLs
+	ParentID   *primitive.ObjectID  `bson:"parent_id" json:"parent_id"` // nil for top-level categories
+	Ancestors  []primitive.ObjectID `bson:"ancestors" json:"ancestors"`
+	Position   int                  `bson:"position" json:"position"` // order among its siblings
+	CreatedAt  time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+var slugSeparators = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// Slugify turns a category name into its URL form, e.g. "Men's Shoes" into
+// "men-s-shoes". Names differing only in case or punctuation share a slug.
+func Slugify(name string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginThrottle counts recent failed logins for an account or a client IP.
+type LoginThrottle struct {
+	Key          string    `bson:"_id" json:"key"` // "account:<email>" or "ip:<address>"
+	Failures     int       `bson:"failures" json:"failures"`
+	LastFailedAt time.Time `bson:"last_failed_at" json:"last_failed_at"`
+	LockedUntil  time.Time `bson:"locked_until" json:"locked_until"`
+	ExpiresAt    time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// LoginAudit records a failed or blocked login attempt.
+type LoginAudit struct {
+	AuditID   primitive.ObjectID  `bson:"_id" json:"audit_id"`
+	Email     string              `bson:"email" json:"email"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	IP        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"user_agent" json:"user_agent"`
+	Reason    string              `bson:"reason" json:"reason"` // "unknown account", "wrong password", "locked"
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OIDCLogin is an OpenID Connect login in progress. It is created when the
+// user is sent to the provider and consumed by the callback.
+type OIDCLogin struct {
+	State        string    `bson:"_id" json:"-"`
+	Provider     string    `bson:"provider" json:"provider"`
+	Nonce        string    `bson:"nonce" json:"-"`
+	CodeVerifier string    `bson:"code_verifier" json:"-"` // PKCE verifier
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time `bson:"expires_at" json:"expires_at"`
+}
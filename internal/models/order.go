@@ -6,17 +6,60 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Order lifecycle statuses.
+const (
+	OrderStatusPendingPayment = "Pending Payment"
+	OrderStatusPaid           = "Paid"
+	OrderStatusProcessing     = "Processing"
+	OrderStatusShipped        = "Shipped"
+	OrderStatusDelivered      = "Delivered"
+	OrderStatusCancelled      = "Cancelled"
+	OrderStatusRefunded       = "Refunded"
+	OrderStatusReturned       = "Returned"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+var orderTransitions = map[string][]string{
+	OrderStatusPendingPayment: {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:           {OrderStatusProcessing, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusProcessing:     {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:        {OrderStatusDelivered, OrderStatusReturned},
+	OrderStatusDelivered:      {OrderStatusReturned, OrderStatusRefunded},
+	OrderStatusReturned:       {OrderStatusRefunded},
+	OrderStatusCancelled:      {},
+	OrderStatusRefunded:       {},
+}
+
+// IsOrderStatus reports whether status is a known order status.
+func IsOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+// CanTransitionOrder reports whether an order may move from one status to another.
+func CanTransitionOrder(from string, to string) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Order struct {
-	OrderID       primitive.ObjectID `bson:"_id" json:"order_id"`
-	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Items         []OrderItem        `bson:"items" json:"items"`
-	TotalPrice    float64            `bson:"total_price" json:"total_price"`
-	Discount      *float64           `bson:"discount" json:"discount"`
-	OrderedAt     time.Time          `bson:"ordered_at" json:"ordered_at"`
-	PaymentMethod Payment            `bson:"payment_method" json:"payment_method"`
-	Status        string             `bson:"status" json:"status"` //"Processing", "Shipped", "Delivered"
-	TransactionID string             `bson:"transaction_id" json:"transaction_id"`
-	PaymentStatus string             `bson:"payment_status" json:"payment_status"` //"Pending", "Succeeded", "Failed"
+	OrderID       primitive.ObjectID  `bson:"_id" json:"order_id"`
+	OrderNumber   string              `bson:"order_number,omitempty" json:"order_number,omitempty"` // short reference shown to customers
+	UserID        primitive.ObjectID  `bson:"user_id" json:"user_id"`                               // zero for guest orders
+	GuestEmail    string              `bson:"guest_email,omitempty" json:"guest_email,omitempty"`
+	Items         []OrderItem         `bson:"items" json:"items"`
+	TotalPrice    float64             `bson:"total_price" json:"total_price"`
+	Discount      *float64            `bson:"discount" json:"discount"`
+	OrderedAt     time.Time           `bson:"ordered_at" json:"ordered_at"`
+	PaymentMethod Payment             `bson:"payment_method" json:"payment_method"`
+	Status        string              `bson:"status" json:"status"` // one of the OrderStatus constants
+	StatusHistory []OrderStatusChange `bson:"status_history" json:"status_history"`
+	TransactionID string              `bson:"transaction_id" json:"transaction_id"`
+	PaymentStatus string              `bson:"payment_status" json:"payment_status"` //"Pending", "Succeeded", "Failed", "Refunded"
 }
 
 type OrderItem struct {
@@ -25,3 +68,13 @@ type OrderItem struct {
 	Quantity  int                `bson:"quantity" json:"quantity"`
 	Price     float64            `bson:"price" json:"price"`
 }
+
+// OrderStatusChange records one step of an order's lifecycle.
+type OrderStatusChange struct {
+	From            string              `bson:"from,omitempty" json:"from,omitempty"`
+	Status          string              `bson:"status" json:"status"`
+	ChangedBy       *primitive.ObjectID `bson:"changed_by,omitempty" json:"changed_by,omitempty"`                 // nil for system changes such as payment webhooks
+	ChangedByAPIKey *primitive.ObjectID `bson:"changed_by_api_key,omitempty" json:"changed_by_api_key,omitempty"` // set instead of ChangedBy for changes made by an integration
+	ChangedAt       time.Time           `bson:"changed_at" json:"changed_at"`
+	Reason          string              `bson:"reason,omitempty" json:"reason,omitempty"`
+}
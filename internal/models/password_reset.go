@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a one-time password reset token. Only its hash is stored.
+type PasswordReset struct {
+	ResetID   primitive.ObjectID `bson:"_id" json:"reset_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
@@ -6,10 +6,31 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Payment statuses shared by models.Payment and Order.PaymentStatus.
+const (
+	PaymentStatusPending   = "Pending"
+	PaymentStatusSucceeded = "Succeeded"
+	PaymentStatusFailed    = "Failed"
+	PaymentStatusRefunded  = "Refunded"
+)
+
 type Payment struct {
-	PaymentID primitive.ObjectID `bson:"_id" json:"payment_id"`
-	Method    string             `bson:"method" json:"method"` //  "Credit Card", "PayPal")
-	Status    string             `bson:"status" json:"status"` //  "Pending", "Completed")
-	Amount    float64            `bson:"amount" json:"amount"`
-	PaidAt    *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	PaymentID      primitive.ObjectID `bson:"_id" json:"payment_id"`
+	OrderID        primitive.ObjectID `bson:"order_id" json:"order_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TransactionID  string             `bson:"transaction_id" json:"transaction_id"` // Stripe PaymentIntent ID
+	Method         string             `bson:"method" json:"method"`                 //  "Credit Card", "PayPal")
+	Status         string             `bson:"status" json:"status"`                 //  "Pending", "Succeeded", "Failed", "Refunded"
+	Amount         float64            `bson:"amount" json:"amount"`
+	Currency       string             `bson:"currency" json:"currency"`
+	FailureMessage string             `bson:"failure_message,omitempty" json:"failure_message,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	PaidAt         *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+}
+
+// PaymentEvent records a processed Stripe webhook event so redeliveries are ignored.
+type PaymentEvent struct {
+	EventID    string    `bson:"_id" json:"event_id"`
+	Type       string    `bson:"type" json:"type"`
+	ReceivedAt time.Time `bson:"received_at" json:"received_at"`
 }
@@ -7,14 +7,19 @@ import (
 )
 
 type Product struct {
-	ProductID     primitive.ObjectID `bson:"_id" json:"product_id"`
-	Name          string             `bson:"name" json:"name"`
-	Description   string             `bson:"description" json:"description"`
-	Price         float64            `bson:"price" json:"price"`
-	StockQuantity int                `bson:"stock_quantity" json:"stock_quantity"`
-	Category      string             `bson:"category" json:"category"`
-	Images        []string           `bson:"images" json:"images"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
-	Discount      *float64           `bson:"discount" json:"discount"`
+	ProductID     primitive.ObjectID  `bson:"_id" json:"product_id"`
+	Name          string              `bson:"name" json:"name"`
+	NameLower     string              `bson:"name_lower" json:"-"` // for prefix search, kept in step with Name
+	Description   string              `bson:"description" json:"description"`
+	Price         float64             `bson:"price" json:"price"`
+	StockQuantity int                 `bson:"stock_quantity" json:"stock_quantity"`
+	CategoryID    *primitive.ObjectID `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	Category      string              `bson:"category" json:"category"` // name of the category, kept for search and display
+	Images        []string            `bson:"images" json:"images"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updated_at"`
+	Discount      *float64            `bson:"discount" json:"discount"`
+	Rating        float64             `bson:"rating" json:"rating"`             // average review rating, kept up to date by the review handlers
+	ReviewCount   int                 `bson:"review_count" json:"review_count"` // number of reviews Rating is the average of
+	SoldCount     int                 `bson:"sold_count" json:"sold_count"`     // units ordered and not cancelled, the popularity sort key
 }
@@ -0,0 +1,51 @@
+package models
+
+// User roles.
+const (
+	RoleAdmin          = "admin"
+	RoleCustomer       = "customer"
+	RoleCatalogManager = "catalog_manager"
+	RoleOrderManager   = "order_manager"
+	RoleModerator      = "moderator"
+)
+
+// Permissions granted to roles.
+const (
+	PermissionCatalogWrite    = "catalog:write"
+	PermissionOrdersManage    = "orders:manage"
+	PermissionReviewsModerate = "reviews:moderate"
+	PermissionUsersManage     = "users:manage"
+	PermissionAPIKeysManage   = "api_keys:manage"
+)
+
+// rolePermissions lists what each role may do. Staff roles get a narrow slice
+// of what admins can do.
+var rolePermissions = map[string][]string{
+	RoleAdmin:          {PermissionCatalogWrite, PermissionOrdersManage, PermissionReviewsModerate, PermissionUsersManage, PermissionAPIKeysManage},
+	RoleCatalogManager: {PermissionCatalogWrite},
+	RoleOrderManager:   {PermissionOrdersManage},
+	RoleModerator:      {PermissionReviewsModerate},
+	RoleCustomer:       {},
+}
+
+// IsRole reports whether role is a known role.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// IsPermission reports whether permission is a known permission. Admins
+// hold every permission.
+func IsPermission(permission string) bool {
+	return RoleHasPermission(RoleAdmin, permission)
+}
+
+// RoleHasPermission reports whether role grants permission.
+func RoleHasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login of a user. Every refresh token issued for the session
+// rotates RefreshTokenHash, so only the most recent refresh token is accepted.
+type Session struct {
+	SessionID        primitive.ObjectID `bson:"_id" json:"session_id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RefreshTokenHash string             `bson:"refresh_token_hash" json:"-"`
+	MFA              bool               `bson:"mfa" json:"mfa"` // authenticated with a second factor
+	Revoked          bool               `bson:"revoked" json:"revoked"`
+	RevokedReason    string             `bson:"revoked_reason,omitempty" json:"revoked_reason,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+}
@@ -7,14 +7,31 @@ import (
 )
 
 type User struct {
-	UserID      primitive.ObjectID `bson:"_id" json:"user_id"`
-	FirstName   string             `bson:"first_name" json:"first_name"`
-	LastName    string             `bson:"last_name" json:"last_name"`
-	Email       string             `bson:"email" json:"email"`
-	Password    string             `bson:"password" json:"password"`
-	PhoneNumber string             `bson:"phone_number" json:"phone_number"`
-	Address     []Address          `bson:"address" json:"address"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
-	Role        string             `bson:"role" json:"role"` // "admin" or "customer"
+	UserID              primitive.ObjectID `bson:"_id" json:"user_id"`
+	FirstName           string             `bson:"first_name" json:"first_name"`
+	LastName            string             `bson:"last_name" json:"last_name"`
+	Email               string             `bson:"email" json:"email"`
+	Password            string             `bson:"password" json:"-"`
+	PhoneNumber         string             `bson:"phone_number,omitempty" json:"phone_number"` // social login accounts add it to their profile before checkout
+	Address             []Address          `bson:"address" json:"address"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt           time.Time          `bson:"updated_at" json:"updated_at"`
+	Role                string             `bson:"role" json:"role"` // one of the Role constants
+	Disabled            bool               `bson:"disabled" json:"disabled"`
+	EmailVerified       bool               `bson:"email_verified" json:"email_verified"`
+	EmailVerificationID string             `bson:"email_verification_id,omitempty" json:"-"` // ID of the outstanding verification token
+	VerificationSentAt  *time.Time         `bson:"verification_sent_at,omitempty" json:"-"`  // when the last verification email was sent
+	MFAEnabled          bool               `bson:"mfa_enabled" json:"mfa_enabled"`
+	MFASecret           string             `bson:"mfa_secret,omitempty" json:"-"`         // base32 TOTP secret
+	MFAPendingSecret    string             `bson:"mfa_pending_secret,omitempty" json:"-"` // secret awaiting its first code during enrollment
+	MFALastCounter      int64              `bson:"mfa_last_counter,omitempty" json:"-"`   // last accepted TOTP time step, blocks replays
+	RecoveryCodes       []string           `bson:"recovery_codes,omitempty" json:"-"`     // hashes of unused recovery codes
+	Identities          []Identity         `bson:"identities,omitempty" json:"identities,omitempty"`
+}
+
+// Identity links a user to an account at an external identity provider.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"` // the provider's stable user ID
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
 }
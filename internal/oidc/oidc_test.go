@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"aevum-emporium-be/internal/oidc/oidctest"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testRedirectURL = "http://localhost:8080/auth/oidc/mock/callback"
+
+// newTestProvider starts a mock provider that signs everyone in as email and
+// returns a relying party registered with it.
+func newTestProvider(t *testing.T, email string) *Provider {
+	t.Helper()
+
+	server, err := oidctest.NewServer(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	return &Provider{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "aevum",
+		ClientSecret: "secret",
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// authorize signs in at the provider and returns the code it redirects back with.
+func authorize(t *testing.T, p *Provider, state string, nonce string, verifier string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal("AuthCodeURL returned error:", err)
+	}
+	callback, err := oidctest.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(callback.String(), testRedirectURL+"?") {
+		t.Fatalf("redirected to %s, want %s", callback, testRedirectURL)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+
+	authURL, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "aevum",
+		"redirect_uri":          testRedirectURL,
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+	if query.Get("code_verifier") != "" {
+		t.Error("the PKCE verifier was sent to the provider")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+	code := authorize(t, p, "state", "nonce", "verifier")
+
+	claims, err := p.Exchange(context.Background(), code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal("Exchange returned error:", err)
+	}
+	if claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Subject == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// Codes are single-use
+	if _, err := p.Exchange(context.Background(), code, "verifier", "nonce"); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+}
+
+func TestExchangePKCEMismatch(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+	code := authorize(t, p, "state", "nonce", "verifier")
+
+	_, err := p.Exchange(context.Background(), code, "another-verifier", "nonce")
+	if err == nil {
+		t.Fatal("Exchange accepted the wrong PKCE verifier")
+	}
+	if errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("PKCE failure reported as an invalid ID token: %v", err)
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+	code := authorize(t, p, "state", "nonce", "verifier")
+
+	_, err := p.Exchange(context.Background(), code, "verifier", "another-nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Exchange with the wrong nonce = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestExchangeWrongAudience(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+	code := authorize(t, p, "state", "nonce", "verifier")
+
+	// The token is issued to "aevum", another client must not accept it
+	p.ClientID = "someone-else"
+	_, err := p.Exchange(context.Background(), code, "verifier", "nonce")
+	if err == nil {
+		t.Error("Exchange accepted a code issued to another client")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := newTestProvider(t, "ada@example.com")
+	p.Issuer = strings.Replace(p.Issuer, "127.0.0.1", "localhost", 1)
+
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("AuthCodeURL accepted metadata of another issuer")
+	}
+}
+
+func TestProvidersFromEnv(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", " Google, mock ,")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com/")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-id")
+	t.Setenv("OIDC_MOCK_REDIRECT_URL", "https://shop.example.com/callback")
+	t.Setenv("OIDC_GOOGLE_REDIRECT_URL", "")
+
+	providers := ProvidersFromEnv("https://api.example.com")
+	if len(providers) != 2 {
+		t.Fatalf("got %d providers, want 2", len(providers))
+	}
+	google := providers["google"]
+	if google == nil || google.Issuer != "https://accounts.google.com" || google.ClientID != "google-id" ||
+		google.RedirectURL != "https://api.example.com/auth/oidc/google/callback" {
+		t.Errorf("google = %+v", google)
+	}
+	if mock := providers["mock"]; mock == nil || mock.RedirectURL != "https://shop.example.com/callback" {
+		t.Errorf("mock = %+v", mock)
+	}
+}
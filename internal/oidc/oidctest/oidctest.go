@@ -0,0 +1,255 @@
+// Package oidctest is an OpenID Connect provider for trying out and testing
+// social login without a real provider. It signs in every visitor right
+// away, as the email given by the login_hint query parameter or a default,
+// and accepts any client ID and secret.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mock-oidc"
+
+// authorization is an issued authorization code waiting to be redeemed.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	expiresAt     time.Time
+}
+
+// Provider serves discovery, the authorization and token endpoints and the
+// JWKS of a provider with the given issuer URL.
+type Provider struct {
+	issuer       string
+	defaultEmail string
+	key          *rsa.PrivateKey
+	mux          *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// NewProvider returns a provider with a new signing key. issuer is the URL it
+// is served at; defaultEmail is who signs in when no login_hint is given.
+func NewProvider(issuer string, defaultEmail string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		defaultEmail: defaultEmail,
+		key:          key,
+		mux:          http.NewServeMux(),
+		codes:        make(map[string]authorization),
+	}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	return p, nil
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// NewServer starts a provider on a local test server. The caller closes it.
+func NewServer(defaultEmail string) (*httptest.Server, error) {
+	server := httptest.NewUnstartedServer(nil)
+	p, err := NewProvider("http://"+server.Listener.Addr().String(), defaultEmail)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	server.Config.Handler = p
+	server.Start()
+	return server, nil
+}
+
+// Authorize visits an authorization URL of the provider like a browser would
+// and returns the redirect back to the client, which carries the code and state.
+func Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorize returned %d", resp.StatusCode)
+	}
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize skips the sign-in page and redirects back with a code at once.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "only the authorization code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		email = p.defaultEmail
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		email:         email,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(auth.expiresAt) ||
+		r.PostForm.Get("client_id") != auth.clientID ||
+		r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	subject := sha256.Sum256([]byte(auth.email))
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"aud":            auth.clientID,
+		"sub":            hex.EncodeToString(subject[:8]),
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": true,
+		"given_name":     strings.Split(auth.email, "@")[0],
+		"family_name":    "Mock",
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	publicKey := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	})
+}
@@ -3,37 +3,108 @@ package routes
 import (
 	"aevum-emporium-be/internal/controllers"
 	"aevum-emporium-be/internal/middleware"
+	"aevum-emporium-be/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRoutes(router *gin.Engine) {
+	// Public keys other services verify our tokens with
+	router.GET("/.well-known/jwks.json", controllers.JWKS())
+
 	// Auth Routes
 	authGroup := router.Group("/auth/user")
 	{
 		authGroup.POST("/signup", controllers.SignUp())
 		authGroup.POST("/login", controllers.Login())
+		authGroup.POST("/refresh", controllers.RefreshToken())
+		authGroup.POST("/logout", middleware.AuthMiddleware(), controllers.Logout())
+		authGroup.POST("/logout-all", middleware.AuthMiddleware(), controllers.LogoutAll())
+		authGroup.GET("/verify", controllers.VerifyEmail())
+		authGroup.POST("/verify/resend", middleware.AuthMiddleware(), controllers.ResendVerificationEmail())
+		authGroup.POST("/verify/request", controllers.RequestVerificationEmail())
+		authGroup.POST("/forgot-password", controllers.ForgotPassword())
+		authGroup.POST("/reset-password", controllers.ResetPassword())
+		authGroup.POST("/mfa/enroll", middleware.AuthMiddleware(), controllers.EnrollMFA())
+		authGroup.POST("/mfa/activate", middleware.AuthMiddleware(), controllers.ActivateMFA())
+		authGroup.POST("/mfa/verify", controllers.VerifyMFA())
+		authGroup.POST("/mfa/disable", middleware.AuthMiddleware(), controllers.DisableMFA())
+	}
+
+	// External Login Routes
+	oidcGroup := router.Group("/auth/oidc")
+	{
+		oidcGroup.GET("/:provider/login", controllers.OIDCLogin())
+		oidcGroup.GET("/:provider/callback", controllers.OIDCCallback())
+	}
+
+	// Profile Routes
+	profileGroup := router.Group("/users/me")
+	{
+		profileGroup.GET("", middleware.AuthMiddleware(), controllers.GetProfile())
+		profileGroup.PATCH("", middleware.AuthMiddleware(), controllers.UpdateProfile())
+		profileGroup.POST("/password", middleware.AuthMiddleware(), controllers.ChangePassword())
+	}
+
+	// User Management Routes
+	userAdminGroup := router.Group("/admin/users")
+	{
+		userAdminGroup.GET("/", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionUsersManage), controllers.ListUsers())
+		userAdminGroup.PUT("/:user_id/role", middleware.AuthMiddleware(), middleware.RequireRole(models.RoleAdmin), controllers.UpdateUserRole())
+		userAdminGroup.POST("/:user_id/disable", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionUsersManage), controllers.SetUserDisabled(true))
+		userAdminGroup.POST("/:user_id/enable", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionUsersManage), controllers.SetUserDisabled(false))
+	}
+
+	// API Key Management Routes
+	apiKeyGroup := router.Group("/admin/api-keys")
+	{
+		apiKeyGroup.GET("/", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionAPIKeysManage), controllers.ListAPIKeys())
+		apiKeyGroup.POST("/", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionAPIKeysManage), controllers.CreateAPIKey())
+		apiKeyGroup.DELETE("/:key_id", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionAPIKeysManage), controllers.RevokeAPIKey())
 	}
 
 	// Product Routes
 	productGroup := router.Group("/product")
 	{
-		productGroup.POST("/add", middleware.AuthMiddleware(), controllers.AddProduct())
-		productGroup.PUT("/:product_id", middleware.AuthMiddleware(), controllers.UpdateProduct())
-		productGroup.DELETE("/:product_id", middleware.AuthMiddleware(), controllers.DeleteProduct())
+		productGroup.POST("/add", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.AddProduct())
+		productGroup.PUT("/:product_id", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.UpdateProduct())
+		productGroup.DELETE("/:product_id", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.DeleteProduct())
+		productGroup.POST("/:product_id/stock", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.AdjustProductStock())
 
 		productGroup.GET("/", controllers.GetProducts())
 		productGroup.GET("/:product_id", controllers.GetProductByID())
-		productGroup.GET("/search", controllers.SearchProductByCategoryOrName())
+		productGroup.GET("/search", controllers.SearchProducts())
+		productGroup.GET("/suggest", controllers.SuggestProducts())
+	}
+
+	// Category Routes, :category is a category ID or slug
+	categoryGroup := router.Group("/categories")
+	{
+		categoryGroup.POST("/", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.CreateCategory())
+		categoryGroup.PUT("/:category", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.UpdateCategory())
+		categoryGroup.DELETE("/:category", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionCatalogWrite), controllers.DeleteCategory())
+
+		categoryGroup.GET("/", controllers.GetCategoryTree())
+		categoryGroup.GET("/:category", controllers.GetCategory())
 	}
 
 	// Order Routes
 	orderGroup := router.Group("/orders")
 	{
 		orderGroup.POST("/place", middleware.AuthMiddleware(), controllers.PlaceOrder())
+		orderGroup.POST("/checkout", middleware.AuthMiddleware(), controllers.Checkout())
 		orderGroup.GET("/", middleware.AuthMiddleware(), controllers.GetOrders())
-		orderGroup.PUT("/:order_id/status", middleware.AuthMiddleware(), controllers.UpdateOrder())
-		orderGroup.DELETE("/:order_id", middleware.AuthMiddleware(), controllers.CancelOrder())
+		orderGroup.GET("/all", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionOrdersManage), controllers.ListAllOrders())
+		orderGroup.PUT("/:order_id/status", middleware.APIKeyOrAuthMiddleware(), middleware.RequirePermission(models.PermissionOrdersManage), controllers.UpdateOrder())
+		orderGroup.POST("/:order_id/cancel", middleware.AuthMiddleware(), controllers.CancelOrder())
+	}
+
+	// Payment Routes
+	paymentGroup := router.Group("/payments")
+	{
+		paymentGroup.POST("/webhook", controllers.StripeWebhook())
+		paymentGroup.POST("/:order_id/intent", middleware.AuthMiddleware(), controllers.CreatePaymentIntent())
+		paymentGroup.POST("/:order_id/confirm", middleware.AuthMiddleware(), controllers.ConfirmPayment())
 	}
 
 	// Cart Routes
@@ -46,6 +117,19 @@ func SetupRoutes(router *gin.Engine) {
 
 	}
 
+	// Guest Routes, authorised by the X-Cart-Token and X-Order-Token headers
+	guestGroup := router.Group("/guest")
+	{
+		guestGroup.POST("/cart", controllers.AddToCart())
+		guestGroup.GET("/cart", controllers.ViewCart())
+		guestGroup.DELETE("/cart/:product_id", controllers.RemoveFromCart())
+		guestGroup.DELETE("/cart/clear", controllers.ClearCart())
+		guestGroup.POST("/checkout", controllers.GuestCheckout())
+		guestGroup.POST("/orders/lookup", controllers.LookupGuestOrder())
+		guestGroup.POST("/orders/:order_id/intent", controllers.CreatePaymentIntent())
+		guestGroup.POST("/orders/:order_id/confirm", controllers.ConfirmPayment())
+	}
+
 	// Address Routes
 	addressGroup := router.Group("/address")
 	{
@@ -68,7 +152,7 @@ func SetupRoutes(router *gin.Engine) {
 	{
 		reviewGroup.POST("/add", middleware.AuthMiddleware(), controllers.AddReview())
 		reviewGroup.GET("/:product_id", controllers.GetReviewsByProduct())
-		reviewGroup.DELETE("/:review_id", middleware.AuthMiddleware(), controllers.DeleteReview())
+		reviewGroup.DELETE("/:review_id", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermissionReviewsModerate), controllers.DeleteReview())
 	}
 
 }
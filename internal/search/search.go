@@ -0,0 +1,248 @@
+// Package search holds the text handling of product search that MongoDB does
+// not do for us: splitting queries into terms, correcting misspelled terms
+// against a vocabulary, suggesting alternative queries and highlighting
+// matches in result text.
+package search
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minTermLength is the length below which words are neither corrected nor
+// collected into a vocabulary; short words are too easily "corrected" into
+// something else.
+const minTermLength = 3
+
+// Terms splits text into lowercase words.
+func Terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Vocabulary returns the distinct words of texts that are long enough to
+// correct queries against.
+func Vocabulary(texts []string) []string {
+	seen := make(map[string]bool)
+	words := make([]string, 0)
+	for _, text := range texts {
+		for _, word := range Terms(text) {
+			if len([]rune(word)) >= minTermLength && !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+// Distance returns the Levenshtein edit distance between a and b.
+func Distance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// maxDistance is the number of typos tolerated in a word of length n.
+func maxDistance(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Correct replaces every term that is not in vocabulary with the closest
+// vocabulary word within the typo tolerance for its length. It reports whether
+// any term was replaced. Ties go to the alphabetically first word, so the
+// same query is always corrected the same way.
+func Correct(terms []string, vocabulary []string) ([]string, bool) {
+	known := make(map[string]bool, len(vocabulary))
+	for _, word := range vocabulary {
+		known[word] = true
+	}
+
+	corrected := make([]string, len(terms))
+	changed := false
+	for i, term := range terms {
+		corrected[i] = term
+		if known[term] {
+			continue
+		}
+
+		best, bestDistance := "", maxDistance(len([]rune(term)))+1
+		for _, word := range vocabulary {
+			// Words whose lengths differ by more than the tolerance cannot be close enough
+			if diff := len([]rune(word)) - len([]rune(term)); diff >= bestDistance || -diff >= bestDistance {
+				continue
+			}
+			if d := Distance(term, word); d < bestDistance {
+				best, bestDistance = word, d
+			}
+		}
+		if best != "" {
+			corrected[i] = best
+			changed = true
+		}
+	}
+	return corrected, changed
+}
+
+// Highlight returns text HTML-escaped with the words containing any of terms
+// wrapped in <em>. Text longer than maxLength is cut to a snippet of
+// about that length around the first match, with "…" marking the cuts. It
+// returns "" when nothing matches.
+func Highlight(text string, terms []string, maxLength int) string {
+	if len(terms) == 0 {
+		return ""
+	}
+	patterns := make([]string, len(terms))
+	for i, term := range terms {
+		patterns[i] = regexp.QuoteMeta(term)
+	}
+	// A word is a run of letters and digits, as in Terms
+	matcher, err := regexp.Compile(`(?i)[\pL\pN]*(?:` + strings.Join(patterns, "|") + `)[\pL\pN]*`)
+	if err != nil {
+		return ""
+	}
+
+	matches := matcher.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	// Center the snippet on the first match, on word boundaries
+	start, end := 0, len(text)
+	if maxLength > 0 && len(text) > maxLength {
+		start = max(matches[0][0]-maxLength/3, 0)
+		// The snippet always holds the whole first match
+		end = min(max(start+maxLength, matches[0][1]), len(text))
+		if start > 0 {
+			if i := strings.IndexByte(text[start:], ' '); i >= 0 && start+i < matches[0][0] {
+				start += i + 1
+			}
+		}
+		if end < len(text) {
+			if i := strings.LastIndexByte(text[start:end], ' '); i > 0 && start+i >= matches[0][1] {
+				end = start + i
+			}
+		}
+		// Never cut a character in half
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	position := start
+	for _, match := range matches {
+		if match[0] < start || match[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[position:match[0]]))
+		b.WriteString("<em>")
+		b.WriteString(html.EscapeString(text[match[0]:match[1]]))
+		b.WriteString("</em>")
+		position = match[1]
+	}
+	b.WriteString(html.EscapeString(text[position:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// Suggestions returns up to limit alternative queries for terms that found
+// nothing, closest first. Unknown terms are replaced with vocabulary words a
+// little further away than Correct allows, since the corrected query already
+// failed.
+func Suggestions(terms []string, vocabulary []string, limit int) []string {
+	known := make(map[string]bool, len(vocabulary))
+	for _, word := range vocabulary {
+		known[word] = true
+	}
+
+	type candidate struct {
+		word     string
+		distance int
+	}
+	candidates := make([][]candidate, len(terms))
+	unknown := false
+	for i, term := range terms {
+		if known[term] {
+			continue
+		}
+		tolerance := maxDistance(len([]rune(term))) + 1
+		for _, word := range vocabulary {
+			if d := Distance(term, word); d <= tolerance {
+				candidates[i] = append(candidates[i], candidate{word, d})
+			}
+		}
+		sort.SliceStable(candidates[i], func(a, b int) bool {
+			return candidates[i][a].distance < candidates[i][b].distance
+		})
+		if len(candidates[i]) > 0 {
+			unknown = true
+		}
+	}
+	if !unknown {
+		return []string{}
+	}
+
+	// The k-th suggestion uses the k-th closest word for every unknown term
+	seen := make(map[string]bool)
+	suggestions := make([]string, 0, limit)
+	for k := 0; k < limit; k++ {
+		words := make([]string, len(terms))
+		more := false
+		for i, term := range terms {
+			switch {
+			case len(candidates[i]) > k:
+				words[i] = candidates[i][k].word
+				more = true
+			case len(candidates[i]) > 0:
+				words[i] = candidates[i][len(candidates[i])-1].word
+			default:
+				words[i] = term
+			}
+		}
+		if !more {
+			break
+		}
+		if suggestion := strings.Join(words, " "); !seen[suggestion] {
+			seen[suggestion] = true
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions
+}
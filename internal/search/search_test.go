@@ -0,0 +1,212 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"Brass Sextant", []string{"brass", "sextant"}},
+		{"pocket-watch, 1920s!", []string{"pocket", "watch", "1920s"}},
+		{"  multiple   spaces\tand\ntabs ", []string{"multiple", "spaces", "and", "tabs"}},
+		{"Café Crème", []string{"café", "crème"}},
+		{"ÜBER Straße", []string{"über", "straße"}},
+		{"日本 時計", []string{"日本", "時計"}},
+		{"emoji 🕰️ clock", []string{"emoji", "clock"}},
+	}
+	for _, tt := range tests {
+		got := Terms(tt.text)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestVocabulary(t *testing.T) {
+	got := Vocabulary([]string{"Brass Sextant", "brass telescope", "An old map", "Café"})
+	want := []string{"brass", "café", "map", "old", "sextant", "telescope"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Vocabulary = %q, want %q", got, want)
+	}
+	if got := Vocabulary(nil); len(got) != 0 {
+		t.Errorf("Vocabulary(nil) = %q, want none", got)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"same", "same", 0},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"sextant", "sextnat", 2},
+		{"brass", "bras", 1},
+		{"brass", "brasss", 1},
+		{"watch", "wetch", 1},
+		// Runes count as one edit, not one per byte
+		{"café", "cafe", 1},
+		{"日本", "日米", 1},
+		{"straße", "strasse", 2},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Distance(tt.b, tt.a); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestCorrect(t *testing.T) {
+	vocabulary := []string{"brass", "chronometer", "compass", "crème", "map", "sextant", "telescope", "watch"}
+
+	tests := []struct {
+		name    string
+		terms   []string
+		want    []string
+		changed bool
+	}{
+		{"no terms", []string{}, []string{}, false},
+		{"known terms", []string{"brass", "watch"}, []string{"brass", "watch"}, false},
+		{"one typo", []string{"brss"}, []string{"brass"}, true},
+		{"two typos in a long word", []string{"cronometr"}, []string{"chronometer"}, true},
+		{"two typos in a short word", []string{"sxtnt"}, []string{"sxtnt"}, false},
+		{"short words are not corrected", []string{"mop"}, []string{"mop"}, false},
+		{"only the unknown term changes", []string{"brass", "telscope"}, []string{"brass", "telescope"}, true},
+		{"nothing close", []string{"umbrella"}, []string{"umbrella"}, false},
+		{"unicode", []string{"creme"}, []string{"crème"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := Correct(tt.terms, vocabulary)
+			if !reflect.DeepEqual(got, tt.want) || changed != tt.changed {
+				t.Errorf("Correct(%q) = %q, %v, want %q, %v", tt.terms, got, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
+
+func TestCorrectTiesGoToFirstWord(t *testing.T) {
+	// "bast" is one edit from both "bass" and "best"
+	for i := 0; i < 10; i++ {
+		got, _ := Correct([]string{"bast"}, []string{"bass", "best"})
+		if got[0] != "bass" {
+			t.Fatalf("Correct(bast) = %q, want bass", got[0])
+		}
+	}
+}
+
+func TestCorrectEmptyVocabulary(t *testing.T) {
+	got, changed := Correct([]string{"brass"}, nil)
+	if changed || got[0] != "brass" {
+		t.Errorf("Correct with no vocabulary = %q, %v", got, changed)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		terms     []string
+		maxLength int
+		want      string
+	}{
+		{"no terms", "Brass sextant", nil, 0, ""},
+		{"no match", "Brass sextant", []string{"watch"}, 0, ""},
+		{"empty text", "", []string{"brass"}, 0, ""},
+		{"whole word", "Brass sextant", []string{"sextant"}, 0, "Brass <em>sextant</em>"},
+		{"case insensitive", "BRASS sextant", []string{"brass"}, 0, "<em>BRASS</em> sextant"},
+		{"partial match marks the whole word", "Telescopes and more", []string{"scope"}, 0, "<em>Telescopes</em> and more"},
+		{"every match", "brass and more brass", []string{"brass"}, 0, "<em>brass</em> and more <em>brass</em>"},
+		{"several terms", "Brass sextant", []string{"brass", "sextant"}, 0, "<em>Brass</em> <em>sextant</em>"},
+		{"html is escaped", "<b>Brass</b> & co", []string{"brass"}, 0, "&lt;b&gt;<em>Brass</em>&lt;/b&gt; &amp; co"},
+		{"regexp characters in terms", "Size (XL) only", []string{"(xl)"}, 0, "Size <em>(XL)</em> only"},
+		{"unicode word", "Café crème brûlée", []string{"crème"}, 0, "Café <em>crème</em> brûlée"},
+		{"unicode letters belong to the word", "Café crème brûlée", []string{"caf"}, 0, "<em>Café</em> crème brûlée"},
+		{"unicode case folding", "ÜBER alles", []string{"über"}, 0, "<em>ÜBER</em> alles"},
+		{"short text is not cut", "Brass sextant", []string{"brass"}, 100, "<em>Brass</em> sextant"},
+		{
+			"snippet around the first match",
+			"An old and well kept instrument, this brass sextant was used at sea for decades",
+			[]string{"sextant"}, 30,
+			"…brass <em>sextant</em> was used at…",
+		},
+		{
+			"snippet at the start",
+			"Sextant of brass, used at sea for decades by a ship captain",
+			[]string{"sextant"}, 20,
+			"<em>Sextant</em> of brass,…",
+		},
+		{
+			"snippet never cuts a rune",
+			"ééééééééééééééé,crème,ééééééééééééééé",
+			[]string{"crème"}, 20,
+			"…ééé,<em>crème</em>,éééé…",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(tt.text, tt.terms, tt.maxLength); got != tt.want {
+				t.Errorf("Highlight(%q, %q, %d) = %q, want %q", tt.text, tt.terms, tt.maxLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightOffsetsStayValid(t *testing.T) {
+	// Every cut must land on a rune boundary, whatever the snippet length
+	text := "日本の時計 Brass sextant 日本の時計 crème brûlée 日本の時計"
+	for maxLength := 1; maxLength <= len(text)+1; maxLength++ {
+		got := Highlight(text, []string{"sextant", "crème"}, maxLength)
+		if !strings.Contains(got, "<em>") {
+			t.Fatalf("maxLength %d: no match highlighted in %q", maxLength, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Fatalf("maxLength %d: invalid UTF-8 in %q", maxLength, got)
+		}
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	vocabulary := []string{"brass", "bread", "breast", "compass", "sextant", "watch"}
+
+	tests := []struct {
+		name  string
+		terms []string
+		limit int
+		want  []string
+	}{
+		{"no terms", []string{}, 3, []string{}},
+		{"all terms known", []string{"brass", "watch"}, 3, []string{}},
+		{"nothing close", []string{"umbrella"}, 3, []string{}},
+		{"closest first, then vocabulary order", []string{"bress"}, 3, []string{"brass", "bread", "breast"}},
+		{"limit", []string{"bress"}, 1, []string{"brass"}},
+		{"known terms are kept", []string{"bress", "watch"}, 2, []string{"brass watch", "bread watch"}},
+		{"unknown terms without candidates are kept", []string{"bress", "zzzzzzzz"}, 1, []string{"brass zzzzzzzz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Suggestions(tt.terms, vocabulary, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggestions(%q, %d) = %q, want %q", tt.terms, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package token
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var APIKeyData *mongo.Collection = datasource.APIKeyData(datasource.Client)
+
+// APIKeyPrefix starts every API key so leaked keys are easy to recognise.
+const APIKeyPrefix = "aek_"
+
+// apiKeyLastUsedInterval limits how often the last-used time of a key is written.
+const apiKeyLastUsedInterval = time.Minute
+
+var ErrInvalidAPIKey = errors.New("API key is invalid, expired or revoked")
+
+// GenerateAPIKey returns a new random API key, its hash for storage and the
+// short prefix shown to admins.
+func GenerateAPIKey() (key string, hash string, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashToken(key), key[:len(APIKeyPrefix)+6], nil
+}
+
+// AuthenticateAPIKey returns the active API key matching key and records that
+// it was used.
+func AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return apiKey, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	err := APIKeyData.FindOne(ctx, bson.M{
+		"key_hash": HashToken(key),
+		"revoked":  false,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	}).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return apiKey, ErrInvalidAPIKey
+		}
+		return apiKey, err
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		_, err := APIKeyData.UpdateOne(ctx, bson.M{"_id": apiKey.KeyID}, bson.M{"$set": bson.M{"last_used_at": now}})
+		if err != nil {
+			// Not worth failing the request over
+			log.Println("Error recording API key use:", err)
+		}
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,208 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey is returned when tokens are signed before LoadKeys succeeded.
+var ErrNoSigningKey = errors.New("no JWT signing key loaded")
+
+// verificationKey is a public key tokens are accepted from.
+type verificationKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// signingKey is the private key new tokens are signed with.
+type signingKey struct {
+	verificationKey
+	private crypto.Signer
+}
+
+var (
+	currentSigningKey *signingKey
+	verificationKeys  = map[string]verificationKey{}
+	// validMethods are the algorithms of the loaded keys, no other algorithm is accepted
+	validMethods []string
+)
+
+// LoadKeys loads the key pair new tokens are signed with from the PEM file
+// named by JWT_SIGNING_KEY_FILE, and the public keys of previous signing keys
+// from the comma separated JWT_VERIFICATION_KEY_FILES so their tokens stay
+// valid during a rotation. RSA (RS256) and Ed25519 (EdDSA) keys are
+// supported. Keys are identified by their RFC 7638 thumbprint, sent as the
+// kid header.
+func LoadKeys() error {
+	path := os.Getenv("JWT_SIGNING_KEY_FILE")
+	if path == "" {
+		return errors.New("JWT_SIGNING_KEY_FILE is not set")
+	}
+	key, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("%s: the signing key must be a private key", path)
+	}
+	signing, err := newVerificationKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := map[string]verificationKey{signing.kid: signing}
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := readKeyFile(path)
+		if err != nil {
+			return err
+		}
+		// A previous private key may be given as is, only its public half is kept
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		verification, err := newVerificationKey(key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys[verification.kid] = verification
+	}
+
+	methods := make(map[string]bool)
+	for _, key := range keys {
+		methods[key.method.Alg()] = true
+	}
+	validMethods = make([]string, 0, len(methods))
+	for method := range methods {
+		validMethods = append(validMethods, method)
+	}
+
+	currentSigningKey = &signingKey{verificationKey: signing, private: signer}
+	verificationKeys = keys
+	return nil
+}
+
+// readKeyFile parses the first PEM block of a file as a PKCS#8 or PKCS#1
+// private key or a PKIX public key.
+func readKeyFile(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+}
+
+func newVerificationKey(public crypto.PublicKey) (verificationKey, error) {
+	key := verificationKey{public: public}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return key, errors.New("RSA keys must have at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return key, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", public)
+	}
+
+	// RFC 7638: the hash of the required members in lexicographic order
+	thumbprint, err := json.Marshal(key.requiredMembers())
+	if err != nil {
+		return key, err
+	}
+	sum := sha256.Sum256(thumbprint)
+	key.kid = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// requiredMembers returns the JWK members that describe the public key.
+// encoding/json sorts map keys, as the thumbprint requires.
+func (k verificationKey) requiredMembers() map[string]string {
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}
+	}
+	return nil
+}
+
+// JWKS returns the JSON Web Key Set of every key tokens are accepted from,
+// for other services to verify our tokens with.
+func JWKS() map[string]interface{} {
+	keys := make([]map[string]string, 0, len(verificationKeys))
+	for _, key := range verificationKeys {
+		jwk := key.requiredMembers()
+		jwk["kid"] = key.kid
+		jwk["use"] = "sig"
+		jwk["alg"] = key.method.Alg()
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i]["kid"] < keys[j]["kid"] })
+	return map[string]interface{}{"keys": keys}
+}
+
+// signToken signs claims with the current signing key.
+func signToken(claims jwt.Claims) (string, error) {
+	if currentSigningKey == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(currentSigningKey.method, claims)
+	token.Header["kid"] = currentSigningKey.kid
+	return token.SignedString(currentSigningKey.private)
+}
+
+// verificationKeyFunc finds the key a token was signed with by its kid and
+// makes sure the token uses that key's algorithm.
+func verificationKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
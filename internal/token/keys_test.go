@@ -0,0 +1,330 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+// writeFile writes content to a temporary file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePEM writes a single PEM block to a temporary file and returns its path.
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePrivateKey writes key as a PKCS#8 "PRIVATE KEY" file.
+func writePrivateKey(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PRIVATE KEY", der)
+}
+
+// writePublicKey writes key as a PKIX "PUBLIC KEY" file.
+func writePublicKey(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PUBLIC KEY", der)
+}
+
+// loadTestKeys loads signing as the signing key and verification as the keys
+// of previous rotations.
+func loadTestKeys(t *testing.T, signing string, verification ...string) {
+	t.Helper()
+
+	t.Setenv("JWT_SIGNING_KEY_FILE", signing)
+	t.Setenv("JWT_VERIFICATION_KEY_FILES", strings.Join(verification, ","))
+	if err := LoadKeys(); err != nil {
+		t.Fatal("LoadKeys returned error:", err)
+	}
+}
+
+// tokenKid returns the kid header of a signed token without verifying it.
+func tokenKid(t *testing.T, signedtoken string) string {
+	t.Helper()
+
+	token, _, err := jwt.NewParser().ParseUnverified(signedtoken, &SignedDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestLoadKeys(t *testing.T) {
+	rsaKey := newRSAKey(t, 2048)
+
+	tests := []struct {
+		name string
+		path string
+		alg  string
+	}{
+		{"RSA PKCS#8", writePrivateKey(t, rsaKey), "RS256"},
+		{"RSA PKCS#1", writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "RS256"},
+		{"Ed25519", writePrivateKey(t, newEd25519Key(t)), "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestKeys(t, tt.path)
+
+			if len(validMethods) != 1 || validMethods[0] != tt.alg {
+				t.Errorf("validMethods = %v, want [%s]", validMethods, tt.alg)
+			}
+
+			access, _, err := TokenGenerator("ada@example.com", "Ada", "Lovelace", "user-1", "customer", false, "session-1")
+			if err != nil {
+				t.Fatal("TokenGenerator returned error:", err)
+			}
+			token, _, err := jwt.NewParser().ParseUnverified(access, &SignedDetails{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Method.Alg() != tt.alg {
+				t.Errorf("token is signed with %s, want %s", token.Method.Alg(), tt.alg)
+			}
+			if kid := tokenKid(t, access); kid != currentSigningKey.kid {
+				t.Errorf("kid = %q, want the signing key's %q", kid, currentSigningKey.kid)
+			}
+
+			claims, err := ValidateToken(access)
+			if err != nil {
+				t.Fatal("ValidateToken returned error:", err)
+			}
+			if claims.Email != "ada@example.com" || claims.Subject != "user-1" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestLoadKeysRejects(t *testing.T) {
+	ed25519Key := newEd25519Key(t)
+
+	tests := []struct {
+		name         string
+		signing      string
+		verification string
+	}{
+		{"no signing key", "", ""},
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), ""},
+		{"not PEM", writeFile(t, "not a key"), ""},
+		{"certificate", writePEM(t, "CERTIFICATE", []byte{1, 2, 3}), ""},
+		{"public signing key", writePublicKey(t, ed25519Key.Public()), ""},
+		{"short RSA key", writePrivateKey(t, newRSAKey(t, 1024)), ""},
+		{"bad verification key", writePrivateKey(t, ed25519Key), writeFile(t, "not a key")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SIGNING_KEY_FILE", tt.signing)
+			t.Setenv("JWT_VERIFICATION_KEY_FILES", tt.verification)
+			if err := LoadKeys(); err == nil {
+				t.Error("LoadKeys accepted it")
+			}
+		})
+	}
+}
+
+func TestKeyThumbprint(t *testing.T) {
+	// The examples of RFC 7638 section 3.1 and RFC 8037 appendix A.3
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		public interface{}
+		kid    string
+	}{
+		{"RSA", &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{"Ed25519", ed25519.PublicKey(x), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	}
+	for _, tt := range tests {
+		key, err := newVerificationKey(tt.public)
+		if err != nil {
+			t.Fatalf("%s: newVerificationKey returned error: %v", tt.name, err)
+		}
+		if key.kid != tt.kid {
+			t.Errorf("%s: kid = %q, want %q", tt.name, key.kid, tt.kid)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newEd25519Key(t)
+	oldPath := writePrivateKey(t, oldKey)
+	newPath := writePrivateKey(t, newRSAKey(t, 2048))
+
+	loadTestKeys(t, oldPath)
+	oldToken, _, err := TokenGenerator("ada@example.com", "Ada", "Lovelace", "user-1", "customer", false, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := tokenKid(t, oldToken)
+
+	// The retired key verifies given as its private or its public key
+	for name, retired := range map[string]string{
+		"private": oldPath,
+		"public":  writePublicKey(t, oldKey.Public()),
+	} {
+		loadTestKeys(t, newPath, retired)
+		if _, err := ValidateToken(oldToken); err != nil {
+			t.Errorf("token of the retired %s key: %v", name, err)
+		}
+	}
+
+	newToken, _, err := TokenGenerator("ada@example.com", "Ada", "Lovelace", "user-1", "customer", false, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKid(t, newToken); kid == oldKid || kid != currentSigningKey.kid {
+		t.Errorf("new tokens carry kid %q, want the new key's %q", kid, currentSigningKey.kid)
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Error("token of the new key:", err)
+	}
+
+	// Once the retired key is dropped its tokens are rejected
+	loadTestKeys(t, newPath)
+	if _, err := ValidateToken(oldToken); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("token of a dropped key: error = %v, want ErrTokenSignatureInvalid", err)
+	}
+}
+
+func TestUnknownKid(t *testing.T) {
+	loadTestKeys(t, writePrivateKey(t, newEd25519Key(t)))
+
+	for name, kid := range map[string]interface{}{
+		"unknown": "not-a-loaded-key",
+		"missing": nil,
+	} {
+		claims := &SignedDetails{Uid: "user-1", Type: AccessToken, RegisteredClaims: registeredClaims("user-1", accessTokenLifetime)}
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		if kid == nil {
+			delete(token.Header, "kid")
+		} else {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(currentSigningKey.private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateToken(signed); !errors.Is(err, ErrTokenSignatureInvalid) {
+			t.Errorf("%s kid: error = %v, want ErrTokenSignatureInvalid", name, err)
+		}
+	}
+
+	// A key we never loaded cannot borrow the kid of one we did
+	claims := &SignedDetails{Uid: "user-1", Type: AccessToken, RegisteredClaims: registeredClaims("user-1", accessTokenLifetime)}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = currentSigningKey.kid
+	forged, err := token.SignedString(newEd25519Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(forged); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("borrowed kid: error = %v, want ErrTokenSignatureInvalid", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t, 2048)
+	ed25519Key := newEd25519Key(t)
+	loadTestKeys(t, writePrivateKey(t, ed25519Key), writePublicKey(t, rsaKey.Public()))
+
+	keys, ok := JWKS()["keys"].([]map[string]string)
+	if !ok || len(keys) != 2 {
+		t.Fatalf("JWKS() = %v, want two keys", JWKS())
+	}
+	if keys[0]["kid"] > keys[1]["kid"] {
+		t.Error("keys are not sorted by kid")
+	}
+
+	for _, jwk := range keys {
+		if jwk["kid"] == "" || jwk["use"] != "sig" {
+			t.Errorf("key without kid or use: %v", jwk)
+		}
+		if _, ok := verificationKeys[jwk["kid"]]; !ok {
+			t.Errorf("kid %q is not a loaded key", jwk["kid"])
+		}
+		if _, private := jwk["d"]; private {
+			t.Errorf("JWKS publishes a private key: %v", jwk)
+		}
+
+		switch jwk["kty"] {
+		case "OKP":
+			want := base64.RawURLEncoding.EncodeToString(ed25519Key.Public().(ed25519.PublicKey))
+			if jwk["alg"] != "EdDSA" || jwk["crv"] != "Ed25519" || jwk["x"] != want {
+				t.Errorf("Ed25519 key = %v", jwk)
+			}
+			if jwk["kid"] != currentSigningKey.kid {
+				t.Errorf("Ed25519 kid = %q, want the signing key's %q", jwk["kid"], currentSigningKey.kid)
+			}
+		case "RSA":
+			want := base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes())
+			if jwk["alg"] != "RS256" || jwk["e"] != "AQAB" || jwk["n"] != want {
+				t.Errorf("RSA key = %v", jwk)
+			}
+		default:
+			t.Errorf("unexpected key type: %v", jwk)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package token
+
+import (
+	"aevum-emporium-be/internal/datasource"
+	"aevum-emporium-be/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var SessionData *mongo.Collection = datasource.SessionData(datasource.Client)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, the session has been revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrAccountDisabled     = errors.New("account has been disabled")
+)
+
+// HashToken returns the hex SHA-256 of a token so raw tokens are never stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession starts a session for a user who just authenticated and returns
+// its first access/refresh token pair. mfa records whether a second factor
+// was checked, it is carried over to every token of the session.
+func NewSession(ctx context.Context, email string, firstname string, lastname string, uid string, role string, mfa bool) (signedtoken string, signedrefreshtoken string, err error) {
+	userObjectID, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return "", "", err
+	}
+
+	sessionID := primitive.NewObjectID()
+	signedtoken, signedrefreshtoken, err = TokenGenerator(email, firstname, lastname, uid, role, mfa, sessionID.Hex())
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	_, err = SessionData.InsertOne(ctx, models.Session{
+		SessionID:        sessionID,
+		UserID:           userObjectID,
+		RefreshTokenHash: HashToken(signedrefreshtoken),
+		MFA:              mfa,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		ExpiresAt:        now.Add(refreshTokenLifetime),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return signedtoken, signedrefreshtoken, nil
+}
+
+// RevokeSession marks a session as revoked so none of its tokens are accepted anymore.
+func RevokeSession(ctx context.Context, sessionID primitive.ObjectID, reason string) error {
+	_, err := SessionData.UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": reason, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeAllSessions revokes every session of a user, logging them out everywhere.
+func RevokeAllSessions(ctx context.Context, userID primitive.ObjectID, reason string) error {
+	_, err := SessionData.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": reason, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeOtherSessions revokes every session of a user except keep, logging
+// them out on all other devices.
+func RevokeOtherSessions(ctx context.Context, userID primitive.ObjectID, keep primitive.ObjectID, reason string) error {
+	_, err := SessionData.UpdateMany(ctx,
+		bson.M{"user_id": userID, "_id": bson.M{"$ne": keep}, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": reason, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// SessionActive reports whether tokens of the given session are still
+// accepted. Sessions that were revoked, or that expired and were removed by
+// the TTL index, are not.
+func SessionActive(ctx context.Context, sid string) (bool, error) {
+	sessionID, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return false, nil
+	}
+
+	count, err := SessionData.CountDocuments(ctx, bson.M{"_id": sessionID, "revoked": false})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RotateRefreshToken redeems a refresh token for a new token pair. The
+// presented token is invalidated; presenting it again is treated as theft and
+// revokes the whole session.
+func RotateRefreshToken(ctx context.Context, signedrefreshtoken string) (signedtoken string, newrefreshtoken string, err error) {
+	claims, err := ValidateRefreshToken(signedrefreshtoken)
+	if err != nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(claims.Sid)
+	if err != nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	var session models.Session
+	if err := SessionData.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", err
+	}
+	if session.Revoked {
+		return "", "", ErrSessionRevoked
+	}
+
+	presentedHash := HashToken(signedrefreshtoken)
+	if session.RefreshTokenHash != presentedHash {
+		if err := RevokeSession(ctx, sessionID, "refresh token reuse"); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	var user models.User
+	if err := UserData.FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", err
+	}
+	// Disabling an account revokes its sessions, this catches any that were
+	// started while it was being disabled
+	if user.Disabled {
+		if err := RevokeAllSessions(ctx, user.UserID, "account disabled"); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrAccountDisabled
+	}
+
+	// Claims come from the current user document, so role changes apply on refresh
+	signedtoken, newrefreshtoken, err = TokenGenerator(user.Email, user.FirstName, user.LastName, user.UserID.Hex(), user.Role, session.MFA, claims.Sid)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Only swap the hash if nobody rotated this token in the meantime
+	now := time.Now()
+	result, err := SessionData.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "refresh_token_hash": presentedHash, "revoked": false},
+		bson.M{"$set": bson.M{
+			"refresh_token_hash": HashToken(newrefreshtoken),
+			"updated_at":         now,
+			"expires_at":         now.Add(refreshTokenLifetime),
+		}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if result.MatchedCount == 0 {
+		if err := RevokeSession(ctx, sessionID, "refresh token reuse"); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	return signedtoken, newrefreshtoken, nil
+}
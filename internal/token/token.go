@@ -2,97 +2,259 @@ package token
 
 import (
 	"aevum-emporium-be/internal/datasource"
-	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Token types carried in SignedDetails.Type.
+const (
+	AccessToken            = "access"
+	RefreshToken           = "refresh"
+	EmailVerificationToken = "email_verification"
+	MFAChallengeToken      = "mfa_challenge"
+	CartToken              = "cart"
+	GuestOrderToken        = "guest_order"
+)
+
+// Issuer and Audience are the iss and aud claims of every token, set by
+// JWT_ISSUER and JWT_AUDIENCE. Tokens with other values are rejected.
+var (
+	Issuer   = getEnvOrDefault("JWT_ISSUER", "aevum-emporium")
+	Audience = getEnvOrDefault("JWT_AUDIENCE", "aevum-emporium-api")
+)
+
+// Token lifetimes. Access and refresh lifetimes are set by JWT_ACCESS_TTL and
+// JWT_REFRESH_TTL as Go durations, e.g. "15m" or "720h".
+var (
+	accessTokenLifetime            = durationFromEnv("JWT_ACCESS_TTL", 24*time.Hour)
+	refreshTokenLifetime           = durationFromEnv("JWT_REFRESH_TTL", 168*time.Hour)
+	emailVerificationTokenLifetime = 24 * time.Hour
+	mfaChallengeTokenLifetime      = 5 * time.Minute
+	CartTokenLifetime              = 30 * 24 * time.Hour
+	guestOrderTokenLifetime        = 24 * time.Hour
+)
+
+// Errors returned by the Validate functions.
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token has expired")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenInvalidIssuer    = errors.New("token has an invalid issuer")
+	ErrTokenInvalidAudience  = errors.New("token has an invalid audience")
+	ErrTokenWrongType        = errors.New("token has the wrong type")
+	ErrTokenInvalid          = errors.New("token is invalid")
+)
+
+// SignedDetails are the claims of our tokens. Subject holds the user ID,
+// duplicated in Uid for existing readers, or the cart or order ID of guest
+// tokens.
 type SignedDetails struct {
-	Email      string
-	First_Name string
-	Last_Name  string
-	Uid        string
+	Email      string `json:"email,omitempty"`
+	First_Name string `json:"first_name,omitempty"`
+	Last_Name  string `json:"last_name,omitempty"`
+	Uid        string `json:"uid,omitempty"`
+	Role       string `json:"role,omitempty"`
+	Mfa        bool   `json:"mfa,omitempty"` // the session was authenticated with a second factor
+	Sid        string `json:"sid,omitempty"` // session the token belongs to
+	Type       string `json:"token_type"`    // one of the token type constants
 	jwt.RegisteredClaims
 }
 
 var UserData *mongo.Collection = datasource.UserData(datasource.Client)
-var SECRET_KEY = os.Getenv("SECRET_KEY")
 
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedtoken string, signedrefreshtoken string, err error) {
+func getEnvOrDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s must be a positive duration such as 15m or 24h, got %q", name, value)
+	}
+	return d
+}
+
+// newTokenID returns a random identifier so no two tokens are ever identical.
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// registeredClaims returns the standard claims of a new token for uid.
+func registeredClaims(uid string, lifetime time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    Issuer,
+		Subject:   uid,
+		Audience:  jwt.ClaimStrings{Audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        newTokenID(),
+	}
+}
+
+func TokenGenerator(email string, firstname string, lastname string, uid string, role string, mfa bool, sid string) (signedtoken string, signedrefreshtoken string, err error) {
 	claims := &SignedDetails{
-		Email:      email,
-		First_Name: firstname,
-		Last_Name:  lastname,
-		Uid:        uid,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+		Email:            email,
+		First_Name:       firstname,
+		Last_Name:        lastname,
+		Uid:              uid,
+		Role:             role,
+		Mfa:              mfa,
+		Sid:              sid,
+		Type:             AccessToken,
+		RegisteredClaims: registeredClaims(uid, accessTokenLifetime),
 	}
 	refreshclaims := &SignedDetails{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(168 * time.Hour)),
-		},
+		Uid:              uid,
+		Sid:              sid,
+		Type:             RefreshToken,
+		RegisteredClaims: registeredClaims(uid, refreshTokenLifetime),
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := signToken(claims)
 	if err != nil {
 		return "", "", err
 	}
-	refreshtoken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshclaims).SignedString([]byte(SECRET_KEY))
+	refreshtoken, err := signToken(refreshclaims)
 	if err != nil {
-		log.Panicln(err)
-		return
+		return "", "", err
 	}
 	return token, refreshtoken, err
 }
 
-func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(signedtoken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
-	})
-
-	if err != nil {
-		msg = err.Error()
-		return
-	}
-	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
-		msg = "The Token is invalid"
-		return
-	}
-	if claims.ExpiresAt.Time.Before(time.Now()) {
-		msg = "token is expired"
-		return
-	}
-	return claims, msg
-}
-
-func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	var updateobj primitive.D
-	updateobj = append(updateobj, bson.E{Key: "token", Value: signedtoken})
-	updateobj = append(updateobj, bson.E{Key: "refresh_token", Value: signedrefreshtoken})
-	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{Key: "updatedat", Value: updated_at})
-	upsert := true
-	filter := bson.M{"user_id": userid}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updateobj},
-	},
-		&opt)
-	defer cancel()
+// GenerateEmailVerificationToken issues a signed token proving ownership of
+// email. The returned token ID must be stored on the user so the token can
+// only be redeemed once.
+func GenerateEmailVerificationToken(email string, uid string) (signedtoken string, tokenID string, err error) {
+	claims := &SignedDetails{
+		Email:            email,
+		Uid:              uid,
+		Type:             EmailVerificationToken,
+		RegisteredClaims: registeredClaims(uid, emailVerificationTokenLifetime),
+	}
+	signedtoken, err = signToken(claims)
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", err
 	}
+	return signedtoken, claims.ID, nil
+}
+
+// GenerateMFAChallengeToken issues the short-lived token a user with two-factor
+// authentication receives after their password is checked. It is exchanged,
+// together with a one-time code, for a real token pair.
+func GenerateMFAChallengeToken(email string, uid string) (signedtoken string, err error) {
+	claims := &SignedDetails{
+		Email:            email,
+		Uid:              uid,
+		Type:             MFAChallengeToken,
+		RegisteredClaims: registeredClaims(uid, mfaChallengeTokenLifetime),
+	}
+	return signToken(claims)
+}
+
+// GenerateCartToken issues the token that lets a guest use the cart cartID.
+func GenerateCartToken(cartID string) (signedtoken string, err error) {
+	claims := &SignedDetails{
+		Type:             CartToken,
+		RegisteredClaims: registeredClaims(cartID, CartTokenLifetime),
+	}
+	return signToken(claims)
+}
+
+// GenerateGuestOrderToken issues the token that lets a guest pay for the
+// order orderID.
+func GenerateGuestOrderToken(orderID string, email string) (signedtoken string, err error) {
+	claims := &SignedDetails{
+		Email:            email,
+		Type:             GuestOrderToken,
+		RegisteredClaims: registeredClaims(orderID, guestOrderTokenLifetime),
+	}
+	return signToken(claims)
+}
+
+// ValidateToken validates an access token.
+func ValidateToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, AccessToken)
+}
 
+// ValidateRefreshToken validates a refresh token.
+func ValidateRefreshToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, RefreshToken)
+}
+
+// ValidateEmailVerificationToken validates an email verification token.
+func ValidateEmailVerificationToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, EmailVerificationToken)
+}
+
+// ValidateMFAChallengeToken validates an MFA challenge token.
+func ValidateMFAChallengeToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, MFAChallengeToken)
+}
+
+// ValidateCartToken validates a cart token. The cart ID is its Subject.
+func ValidateCartToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, CartToken)
+}
+
+// ValidateGuestOrderToken validates a guest order token. The order ID is its Subject.
+func ValidateGuestOrderToken(signedtoken string) (*SignedDetails, error) {
+	return validateTokenOfType(signedtoken, GuestOrderToken)
+}
+
+// validateTokenOfType checks the signature, algorithm, issuer, audience and
+// validity period of a token and that it is of tokenType.
+func validateTokenOfType(signedtoken string, tokenType string) (*SignedDetails, error) {
+	claims := &SignedDetails{}
+	_, err := jwt.ParseWithClaims(signedtoken, claims, verificationKeyFunc,
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrTokenMalformed):
+			return nil, ErrTokenMalformed
+		case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+			return nil, ErrTokenSignatureInvalid
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, ErrTokenExpired
+		case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+			return nil, ErrTokenNotValidYet
+		case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+			return nil, ErrTokenInvalidIssuer
+		case errors.Is(err, jwt.ErrTokenInvalidAudience):
+			return nil, ErrTokenInvalidAudience
+		default:
+			return nil, ErrTokenInvalid
+		}
+	}
+	if claims.Type != tokenType {
+		return nil, ErrTokenWrongType
+	}
+	if claims.Subject == "" || (claims.Uid != "" && claims.Subject != claims.Uid) {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
 }
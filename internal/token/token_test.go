@@ -0,0 +1,225 @@
+package token
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signClaims signs claims with method and key under kid, bypassing signToken
+// so tests can forge tokens it would never issue.
+func signClaims(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// testClaims returns the claims of a valid access token for user-1, changed by edit.
+func testClaims(edit func(*SignedDetails)) *SignedDetails {
+	claims := &SignedDetails{
+		Email:            "ada@example.com",
+		Uid:              "user-1",
+		Role:             "customer",
+		Type:             AccessToken,
+		RegisteredClaims: registeredClaims("user-1", time.Hour),
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	return claims
+}
+
+func TestValidateTokenOfType(t *testing.T) {
+	rsaKey := newRSAKey(t, 2048)
+	// An Ed25519 key from a rotation makes EdDSA a valid method as well
+	loadTestKeys(t, writePrivateKey(t, rsaKey), writePublicKey(t, newEd25519Key(t).Public()))
+	kid := currentSigningKey.kid
+
+	publicDER, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	valid := signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(nil))
+	parts := strings.Split(valid, ".")
+	// Flipping a bit in the middle of the signature keeps it valid base64
+	signature := []byte(parts[2])
+	signature[len(signature)/2] ^= 1
+	tampered := parts[0] + "." + parts[1] + "." + string(signature)
+
+	access, refresh, err := TokenGenerator("ada@example.com", "Ada", "Lovelace", "user-1", "customer", false, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		name      string
+		token     string
+		tokenType string
+		wantErr   error
+	}{
+		{"valid", valid, AccessToken, nil},
+		{"issued access token", access, AccessToken, nil},
+		{"issued refresh token", refresh, RefreshToken, nil},
+		{"refresh token as access token", refresh, AccessToken, ErrTokenWrongType},
+		{"access token as refresh token", access, RefreshToken, ErrTokenWrongType},
+		{"access token as guest order token", access, GuestOrderToken, ErrTokenWrongType},
+		{"empty", "", AccessToken, ErrTokenMalformed},
+		{"garbage", "not.a.token", AccessToken, ErrTokenMalformed},
+		{"tampered signature", tampered, AccessToken, ErrTokenSignatureInvalid},
+		{
+			"alg none",
+			signClaims(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, kid, testClaims(nil)),
+			AccessToken, ErrTokenSignatureInvalid,
+		},
+		{
+			// The classic key confusion: the public key used as an HMAC secret
+			"HS256 with the public key",
+			signClaims(t, jwt.SigningMethodHS256, publicPEM, kid, testClaims(nil)),
+			AccessToken, ErrTokenSignatureInvalid,
+		},
+		{
+			"HS256 with the public key DER",
+			signClaims(t, jwt.SigningMethodHS256, publicDER, kid, testClaims(nil)),
+			AccessToken, ErrTokenSignatureInvalid,
+		},
+		{
+			"EdDSA header on an RSA key",
+			signClaims(t, jwt.SigningMethodEdDSA, newEd25519Key(t), kid, testClaims(nil)),
+			AccessToken, ErrTokenSignatureInvalid,
+		},
+		{
+			"wrong audience",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.Audience = jwt.ClaimStrings{"another-api"}
+			})),
+			AccessToken, ErrTokenInvalidAudience,
+		},
+		{
+			// A missing claim is reported as such, not as a mismatch
+			"no audience",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.Audience = nil
+			})),
+			AccessToken, ErrTokenInvalid,
+		},
+		{
+			"wrong issuer",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.Issuer = "someone-else"
+			})),
+			AccessToken, ErrTokenInvalidIssuer,
+		},
+		{
+			"expired",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.IssuedAt = jwt.NewNumericDate(past)
+				c.NotBefore = jwt.NewNumericDate(past)
+				c.ExpiresAt = jwt.NewNumericDate(past.Add(time.Hour))
+			})),
+			AccessToken, ErrTokenExpired,
+		},
+		{
+			"not valid yet",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			})),
+			AccessToken, ErrTokenNotValidYet,
+		},
+		{
+			"issued in the future",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			})),
+			AccessToken, ErrTokenNotValidYet,
+		},
+		{
+			"no expiry",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.ExpiresAt = nil
+			})),
+			AccessToken, ErrTokenInvalid,
+		},
+		{
+			"no subject",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.Subject = ""
+			})),
+			AccessToken, ErrTokenInvalid,
+		},
+		{
+			"subject and uid differ",
+			signClaims(t, jwt.SigningMethodRS256, rsaKey, kid, testClaims(func(c *SignedDetails) {
+				c.Uid = "user-2"
+			})),
+			AccessToken, ErrTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := validateTokenOfType(tt.token, tt.tokenType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateTokenOfType error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if claims == nil || claims.Subject != "user-1" {
+					t.Errorf("claims = %+v, want the claims of user-1", claims)
+				}
+			} else if claims != nil {
+				t.Errorf("rejected token returned claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestValidateFunctionsCheckTheType(t *testing.T) {
+	loadTestKeys(t, writePrivateKey(t, newEd25519Key(t)))
+
+	verification, _, err := GenerateEmailVerificationToken("ada@example.com", "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := GenerateMFAChallengeToken("ada@example.com", "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token of one kind never passes for another
+	validators := map[string]func(string) (*SignedDetails, error){
+		"ValidateToken":                  ValidateToken,
+		"ValidateRefreshToken":           ValidateRefreshToken,
+		"ValidateEmailVerificationToken": ValidateEmailVerificationToken,
+		"ValidateMFAChallengeToken":      ValidateMFAChallengeToken,
+		"ValidateCartToken":              ValidateCartToken,
+		"ValidateGuestOrderToken":        ValidateGuestOrderToken,
+	}
+	tokens := map[string]string{
+		"ValidateEmailVerificationToken": verification,
+		"ValidateMFAChallengeToken":      challenge,
+	}
+	for tokenName, token := range tokens {
+		for name, validate := range validators {
+			_, err := validate(token)
+			if name == tokenName {
+				if err != nil {
+					t.Errorf("%s rejected its own token: %v", name, err)
+				}
+			} else if !errors.Is(err, ErrTokenWrongType) {
+				t.Errorf("%s with the token of %s: error = %v, want ErrTokenWrongType", name, tokenName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as used
+// by authenticator apps: HMAC-SHA1, 6 digits, 30 second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digits = 6
+	period = 30 * time.Second
+	// skew is the number of steps before and after the current one that are
+	// accepted, to allow for clock drift and slow typing.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32-encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code.
+func ProvisioningURI(secret string, issuer string, account string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(int(period.Seconds())))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Counter returns the time step t falls in.
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(period.Seconds())
+}
+
+// Code returns the one-time password of secret for the given time step.
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// Validate checks code against secret at time t. Codes from time steps at or
+// before lastCounter are rejected so a code cannot be replayed. On success it
+// returns the matched time step, which the caller stores as the new lastCounter.
+func Validate(secret string, code string, t time.Time, lastCounter int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	current := Counter(t)
+	for counter := current - skew; counter <= current+skew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		expected, err := Code(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
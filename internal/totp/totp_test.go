@@ -0,0 +1,190 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC4226(t *testing.T) {
+	// RFC 4226 Appendix D, HOTP values for counters 0 to 9
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := Code(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatalf("Code(%d) returned error: %v", counter, err)
+		}
+		if got != code {
+			t.Errorf("Code(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1. The RFC lists 8 digit codes, the last 6
+	// digits are the 6 digit codes.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d returned error: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || got != "287082" {
+		t.Errorf("Code with lowercase secret = %q, %v, want 287082", got, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret returned no error")
+	}
+}
+
+func TestCounterBoundaries(t *testing.T) {
+	tests := []struct {
+		unix    int64
+		counter int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{60, 2},
+	}
+	for _, tt := range tests {
+		if got := Counter(time.Unix(tt.unix, 0)); got != tt.counter {
+			t.Errorf("Counter(%d) = %d, want %d", tt.unix, got, tt.counter)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	// 1111111111 falls in step 37037037
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{"two steps early", -2, false},
+		{"one step early", -1, true},
+		{"current step", 0, true},
+		{"one step late", 1, true},
+		{"two steps late", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counter, ok := Validate(rfcSecret, code, now, 0)
+			if ok != tt.valid {
+				t.Fatalf("Validate = %v, want %v", ok, tt.valid)
+			}
+			if ok && counter != current+tt.offset {
+				t.Errorf("Validate matched step %d, want %d", counter, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateStepEdges(t *testing.T) {
+	// The first and last second of a step accept the same neighbours
+	code, err := Code(rfcSecret, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		unix  int64
+		valid bool
+	}{
+		{59, false}, // step 1, code is two steps ahead
+		{60, true},  // step 2
+		{89, true},  // step 2
+		{90, true},  // step 3
+		{149, true}, // step 4
+		{150, false},
+	} {
+		if _, ok := Validate(rfcSecret, code, time.Unix(tt.unix, 0), 0); ok != tt.valid {
+			t.Errorf("Validate code of step 3 at %d = %v, want %v", tt.unix, ok, tt.valid)
+		}
+	}
+}
+
+func TestValidateRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, Counter(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter, ok := Validate(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("first use of the code was rejected")
+	}
+	if _, ok := Validate(rfcSecret, code, now, counter); ok {
+		t.Error("the same code was accepted twice")
+	}
+
+	// A code of an earlier step inside the window is a replay too
+	previous, err := Code(rfcSecret, counter-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, previous, now, counter); ok {
+		t.Error("a code older than the last used one was accepted")
+	}
+}
+
+func TestValidateMalformedCodes(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("Validate(%q) was accepted", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 050471 ", now, 0); !ok {
+		t.Error("Validate did not trim surrounding spaces")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(secret, 0); err != nil {
+		t.Errorf("generated secret %q is not usable: %v", secret, err)
+	}
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == other {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}